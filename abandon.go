@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime/pprof"
+	"time"
+)
+
+// Abandoned records a service the supervisor gave up waiting on during shutdown, which may have
+// leaked goroutines.
+type Abandoned struct {
+	Service string
+	Time    time.Time
+	Stack   string // Goroutines of the service at the time, see AbandonedError.
+}
+
+// AbandonedError is the final error of a service abandoned during shutdown.
+type AbandonedError struct {
+	Err   error  // Why the service was abandoned, e.g. ErrStopTimeout.
+	Stack string // Goroutines started by the service, as a goroutine profile.
+}
+
+func (e *AbandonedError) Error() string {
+	return fmt.Sprintf("abandoned: %v", e.Err)
+}
+
+func (e *AbandonedError) Unwrap() error {
+	return e.Err
+}
+
+// Abandoned returns the services abandoned during shutdown by Run.
+func (s *Supervisor) Abandoned() []Abandoned {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Abandoned(nil), s.abandoned...)
+}
+
+// serviceLabel is the profiler label identifying the goroutines of a service.
+const serviceLabel = "service"
+
+// serviceStack returns profiles of the goroutines labeled as belonging to the named service.
+func serviceStack(name string) string {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 1); err != nil {
+		return ""
+	}
+	label := fmt.Appendf(nil, "%q:%q", serviceLabel, name)
+	var stack []byte
+	for entry := range bytes.SplitSeq(buf.Bytes(), []byte("\n\n")) {
+		if bytes.Contains(entry, label) {
+			stack = append(append(stack, entry...), "\n\n"...)
+		}
+	}
+	return string(bytes.TrimSpace(stack))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AckDelivery is a message delivered by a broker, e.g. AMQP, which must be acknowledged.
+type AckDelivery interface {
+	Ack() error
+	Nack(requeue bool) error
+}
+
+// AckConnection is a connection to a broker, e.g. an *amqp.Connection behind a small wrapper.
+type AckConnection[D AckDelivery] interface {
+	Channel() (AckChannel[D], error)
+	Close() error
+}
+
+// AckChannel is a channel of an AckConnection, delivering to consumers identified by a tag.
+type AckChannel[D AckDelivery] interface {
+	// Consume starts delivering to the consumer tag, until Cancel or Close closes deliveries.
+	Consume(tag string) (<-chan D, error)
+	Cancel(tag string) error
+	Close() error
+}
+
+// AckConsumer is a Runner consuming deliveries from a broker, acknowledging each once handled.
+// When stopped it drains: the consumer is canceled so the broker stops delivering, deliveries in
+// flight are handled and acknowledged within the grace period, and then the channel and
+// connection are closed in turn.  Deliveries left unacknowledged are requeued by the broker.
+type AckConsumer[D AckDelivery] struct {
+	dial   func(ctx context.Context) (AckConnection[D], error)
+	tag    string
+	handle func(ctx context.Context, d D) error
+	grace  time.Duration
+
+	conn       AckConnection[D] // Of the current run, set by Start.
+	ch         AckChannel[D]
+	deliveries <-chan D
+}
+
+// NewAckConsumer creates an AckConsumer connecting with dial for each run, and consuming as tag.
+// Deliveries are passed to handle, acked if it returns nil and requeued if not.  Draining is
+// limited to grace, if not zero, as well as by the service's stop timeout.
+func NewAckConsumer[D AckDelivery](dial func(ctx context.Context) (AckConnection[D], error),
+	tag string, handle func(ctx context.Context, d D) error, grace time.Duration) *AckConsumer[D] {
+	return &AckConsumer[D]{dial: dial, tag: tag, handle: handle, grace: grace}
+}
+
+// Start implements Starter, connecting and starting to consume, so the service only becomes
+// ready once deliveries can arrive.
+func (a *AckConsumer[D]) Start(ctx context.Context) error {
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("channel: %w", err)
+	}
+	deliveries, err := ch.Consume(a.tag)
+	if err != nil {
+		ch.Close()
+		conn.Close()
+		return fmt.Errorf("consume: %w", err)
+	}
+	a.conn, a.ch, a.deliveries = conn, ch, deliveries
+	return nil
+}
+
+// Run implements Runner, handling deliveries until ctx is done, and then draining.
+func (a *AckConsumer[D]) Run(ctx context.Context) error {
+	logger := LoggerFromContext(ctx)
+	// Handlers are only canceled once the grace period of draining has expired.
+	hctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	var (
+		wg      sync.WaitGroup
+		unacked atomic.Int64
+	)
+	handle := func(d D) {
+		unacked.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer unacked.Add(-1)
+			var err error
+			if herr := a.handle(hctx, d); herr != nil {
+				logger.Warn("delivery failed, requeueing", "err", herr)
+				err = d.Nack(true)
+			} else {
+				err = d.Ack()
+			}
+			if err != nil {
+				logger.Error("failed to acknowledge delivery", "err", err)
+			}
+		}()
+	}
+consume:
+	for {
+		select {
+		case d, ok := <-a.deliveries:
+			if !ok {
+				// Closed by the broker, rather than our Cancel.
+				wg.Wait()
+				a.close()
+				return errors.New("deliveries closed by broker")
+			}
+			handle(d)
+		case <-ctx.Done():
+			break consume
+		}
+	}
+
+	dctx, dcancel := context.WithoutCancel(ctx), context.CancelFunc(func() {})
+	if a.grace > 0 {
+		dctx, dcancel = context.WithTimeout(dctx, a.grace)
+	}
+	defer dcancel()
+	var err error
+	if cerr := a.ch.Cancel(a.tag); cerr != nil {
+		err = fmt.Errorf("cancel: %w", cerr)
+	} else {
+		// Deliveries already sent before the cancel are still handled, until deliveries closes.
+	drain:
+		for {
+			select {
+			case d, ok := <-a.deliveries:
+				if !ok {
+					break drain
+				}
+				handle(d)
+			case <-dctx.Done():
+				break drain
+			}
+		}
+	}
+	if werr := waitGroup(dctx, &wg); werr != nil {
+		err = errors.Join(err, fmt.Errorf("drain: %d unacked deliveries requeued: %w",
+			unacked.Load(), werr))
+		cancel()
+	}
+	return errors.Join(err, a.close())
+}
+
+// close closes the channel, and then the connection.
+func (a *AckConsumer[D]) close() error {
+	var errs []error
+	if err := a.ch.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close channel: %w", err))
+	}
+	if err := a.conn.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("close connection: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// AdminHandler returns an http.Handler exposing the supervisor for operators and tooling:
+//
+//	GET /info                   build and configuration Info
+//	GET /stats                  uptime and failure Stats of each service
+//	GET /resources              Resources used by the process and reporting services
+//	GET /health                 Health rollup, with status 503 if unhealthy
+//	GET /livez                  Liveness rollup, likewise
+//	GET /readyz                 Readiness rollup, likewise
+//	GET /log-level              LogLevels set at runtime
+//	PUT /log-level/{component}  SetLogLevel to the level in the body, e.g. debug
+//	GET /graph                  Graph of services in DOT format
+//	GET /plan                   start and shutdown Plan, with status 500 if invalid
+//	GET /debug/vars             expvar variables, e.g. from an ExpvarRecorder
+//	POST /restart/{service}     RestartBy the requester, noting the reason in the body
+//
+// Requests are authenticated by WithAdminClients, and rate limited by WithAdminRateLimit.
+func (s *Supervisor) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Info())
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Stats())
+	})
+	mux.HandleFunc("GET /resources", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Resources())
+	})
+	mux.HandleFunc("GET /health", healthHandler(s.Health))
+	mux.HandleFunc("GET /livez", healthHandler(s.Liveness))
+	mux.HandleFunc("GET /readyz", healthHandler(s.Readiness))
+	mux.HandleFunc("GET /log-level", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.LogLevels())
+	})
+	mux.HandleFunc("PUT /log-level/{component}", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, 64))
+		var level slog.Level
+		if err == nil {
+			err = level.UnmarshalText(bytes.TrimSpace(body))
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.SetLogLevel(r.PathValue("component"), level); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.LogLevels())
+	})
+	mux.HandleFunc("GET /graph", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		io.WriteString(w, s.Graph())
+	})
+	mux.HandleFunc("GET /plan", func(w http.ResponseWriter, r *http.Request) {
+		plan, err := s.Plan()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+	})
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("POST /restart/{service}", func(w http.ResponseWriter, r *http.Request) {
+		note, err := io.ReadAll(io.LimitReader(r.Body, 1024))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := r.PathValue("service")
+		if s.service(name) == nil {
+			http.Error(w, fmt.Sprintf("unknown service %q", name), http.StatusNotFound)
+			return
+		}
+		a, err := s.RestartBy(r.Context(), name, s.identify(r), string(bytes.TrimSpace(note)))
+		if errors.Is(err, ErrNotRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		s.logger.Info("operator restart requested", "service", name, "by", a.By, "note", a.Note)
+		writeJSON(w, http.StatusAccepted, a)
+	})
+	return s.adminAuth(mux)
+}
+
+// healthHandler serves the rollup from check, with status 503 if unhealthy.
+func healthHandler(check func(context.Context) Health) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+		defer cancel()
+		h := check(ctx)
+		status := http.StatusOK
+		if h.Status == HealthUnhealthy {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, h)
+	}
+}
+
+// writeJSON writes v as a JSON response with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
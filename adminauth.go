@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// AdminScope is an operation of the AdminHandler a client may be allowed.
+type AdminScope string
+
+const (
+	AdminRead     AdminScope = "read"      // GET endpoints, e.g. /health and /stats.
+	AdminLogLevel AdminScope = "log-level" // PUT /log-level/{component}.
+	AdminRestart  AdminScope = "restart"   // POST /restart/{service}.
+)
+
+// AdminClient is a client allowed to use the AdminHandler, see WithAdminClients, authenticated by
+// a bearer token or the common name of a verified TLS client certificate.
+type AdminClient struct {
+	Name       string       // Identity of the client, e.g. recorded in the Audit of restarts.
+	Token      string       // Bearer token, or empty.
+	CommonName string       // Subject common name of a client certificate, or empty.
+	Allow      []AdminScope // Operations the client may use.
+}
+
+// adminBuckets limits the rate limited admin requesters tracked at once.
+const adminBucketLimit = 1000
+
+// adminClientKey is the request context key for the authenticated AdminClient.
+type adminClientKey struct{}
+
+// adminScope returns the scope required for r.
+func adminScope(r *http.Request) AdminScope {
+	switch {
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		return AdminRead
+	case strings.HasPrefix(r.URL.Path, "/log-level/"):
+		return AdminLogLevel
+	}
+	return AdminRestart
+}
+
+// adminAuth returns next, requiring requests be authenticated as one of our admin clients
+// allowed their scope, if any are configured, and rate limiting those which are not reads.
+func (s *Supervisor) adminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := adminScope(r)
+		if len(s.adminClients) > 0 {
+			c := s.adminClient(r)
+			if c == nil {
+				s.logger.Warn("unauthenticated admin request", "remote", r.RemoteAddr,
+					"path", r.URL.Path)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !slices.Contains(c.Allow, scope) {
+				s.logger.Warn("forbidden admin request", "client", c.Name, "scope", scope,
+					"path", r.URL.Path)
+				http.Error(w, fmt.Sprintf("%s not allowed", scope), http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), adminClientKey{}, c))
+		}
+		if scope != AdminRead && !s.adminAllow(r) {
+			w.Header().Set("Retry-After", fmt.Sprint(int(s.adminRefill.Seconds()+1)))
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminClient returns the admin client r is authenticated as, or nil.
+func (s *Supervisor) adminClient(r *http.Request) *AdminClient {
+	token, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	var cn string
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}
+	for i := range s.adminClients {
+		c := &s.adminClients[i]
+		if bearer && c.Token != "" &&
+			subtle.ConstantTimeCompare([]byte(token), []byte(c.Token)) == 1 {
+			return c
+		}
+		if cn != "" && c.CommonName == cn {
+			return c
+		}
+	}
+	return nil
+}
+
+// adminAllow reports whether the requester of r may make another mutating admin request, taking
+// from its bucket, see WithAdminRateLimit.  Requesters are the authenticated admin client, or the
+// remote host if there are none.
+func (s *Supervisor) adminAllow(r *http.Request) bool {
+	if s.adminBurst <= 0 {
+		return true
+	}
+	id := "host " + r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		id = "host " + host
+	}
+	if c, ok := r.Context().Value(adminClientKey{}).(*AdminClient); ok {
+		id = "client " + c.Name
+	}
+	s.adminMu.Lock()
+	b := s.adminBuckets[id]
+	if b == nil {
+		if len(s.adminBuckets) >= adminBucketLimit {
+			// Forget requesters whose buckets have refilled, as they are idle.
+			for id, b := range s.adminBuckets {
+				if b.Tokens() == s.adminBurst {
+					delete(s.adminBuckets, id)
+				}
+			}
+		}
+		if len(s.adminBuckets) >= adminBucketLimit {
+			s.adminMu.Unlock()
+			return false
+		}
+		b = NewRestartBucket(s.adminBurst, s.adminRefill)
+		s.adminBuckets[id] = b
+	}
+	s.adminMu.Unlock()
+	return b.take()
+}
+
+// validateAdmin checks the admin clients and rate limit.
+func (s *Supervisor) validateAdmin() []error {
+	var errs []error
+	tokens := make(map[string]bool)
+	for _, c := range s.adminClients {
+		if c.Name == "" {
+			errs = append(errs, errors.New("admin client without name"))
+		}
+		if c.Token == "" && c.CommonName == "" {
+			errs = append(errs, fmt.Errorf("admin client %q without token or common name", c.Name))
+		}
+		if c.Token != "" && tokens[c.Token] {
+			errs = append(errs, fmt.Errorf("admin client %q reuses a token", c.Name))
+		}
+		tokens[c.Token] = true
+		for _, scope := range c.Allow {
+			switch scope {
+			case AdminRead, AdminLogLevel, AdminRestart:
+			default:
+				errs = append(errs, fmt.Errorf("admin client %q has unknown scope %q", c.Name,
+					scope))
+			}
+		}
+	}
+	if s.adminBurst < 0 || s.adminRefill < 0 || (s.adminBurst > 0 && s.adminRefill == 0) {
+		errs = append(errs, fmt.Errorf("invalid admin rate limit %v per %v", s.adminBurst,
+			s.adminRefill))
+	}
+	return errs
+}
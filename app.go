@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ErrNoRun may be returned by the build function passed to Main when it has done all there is to
+// do, e.g. printed a plan, for Main to exit successfully without running the supervisor.
+var ErrNoRun = errors.New("nothing to run")
+
+// mainEscalate is how long Main waits after the first signal before aborting shutdown.
+const mainEscalate = time.Second * 30
+
+// Main runs a typical application and exits: it parses flags, calls build to register services
+// on a new supervisor created with opts, runs it until SIGTERM or SIGINT, flushes logs, and exits
+// with a status for the outcome, by ExitCode or WithExitCodes.  A second signal aborts the
+// shutdown, and a third exits with ExitForced.  Main does not return.
+func Main(build func(*Supervisor) error, opts ...SupervisorOption) {
+	os.Exit(runMain(build, opts))
+}
+
+// runMain is Main, returning the exit status so that deferred calls run.
+func runMain(build func(*Supervisor) error, opts []SupervisorOption) int {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+	defer flushLogs()
+	sup := NewSupervisor(opts...)
+	if err := build(sup); errors.Is(err, ErrNoRun) {
+		return ExitClean
+	} else if err != nil {
+		sup.logger.Error("failed to build services", "err", err)
+		return ExitFailed
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	sup.HandleSignals(cancel, mainEscalate, syscall.SIGTERM, syscall.SIGINT)
+	err := sup.Run(ctx)
+	if err != nil {
+		sup.logger.Error("supervisor stopped with errors", "err", err)
+	}
+	if sup.exitCode != nil {
+		return sup.exitCode(ctx, err)
+	}
+	return ExitCode(ctx, err)
+}
+
+// flushLogs flushes the default slog handler, if it buffers, before the process exits.
+func flushLogs() {
+	if f, ok := slog.Default().Handler().(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+}
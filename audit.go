@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Audit records who requested an operator restart, and why, distinguishing it from restarts
+// after failures in events and stats.
+type Audit struct {
+	By   string    `json:"by"`             // Identity of the requester, e.g. from an admin token.
+	Note string    `json:"note,omitempty"` // Why the restart was requested.
+	Time time.Time `json:"time"`
+}
+
+func (a Audit) String() string {
+	if a.Note == "" {
+		return "by " + a.By
+	}
+	return fmt.Sprintf("by %s, %q", a.By, a.Note)
+}
+
+// RestartBy requests the named service be gracefully restarted on behalf of an operator, like
+// Restart with ReasonOperator, recording by and note in its EventStopped and Stats.  It fails
+// with ErrNotRunning if the service is not running, or is paused, so cannot be restarted.
+func (s *Supervisor) RestartBy(ctx context.Context, name, by, note string) (Audit, error) {
+	a := Audit{By: by, Note: note, Time: time.Now()}
+	svc := s.service(name)
+	if svc == nil {
+		return a, fmt.Errorf("unknown service %q", name)
+	}
+	req := restart{svc: svc, reason: ReasonOperator, audit: &a, errc: make(chan error, 1)}
+	select {
+	case s.restartc <- req:
+	default:
+		return a, fmt.Errorf("too many pending restarts, service %q not restarted", name)
+	}
+	select {
+	case err := <-req.errc:
+		return a, err
+	case <-ctx.Done():
+		return a, ctx.Err()
+	}
+}
+
+// identify returns the identity of the requester of an admin request, for the audit trail.
+func (s *Supervisor) identify(r *http.Request) string {
+	if s.adminIdentity != nil {
+		if id := s.adminIdentity(r); id != "" {
+			return id
+		}
+	}
+	if c, ok := r.Context().Value(adminClientKey{}).(*AdminClient); ok {
+		return c.Name
+	}
+	return r.RemoteAddr
+}
+
+// audited records an operator restart of the service.
+func (s *Service) audited(a *Audit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.operatorRestarts++
+	s.lastAudit = a
+}
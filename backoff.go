@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff decides how long to wait before restarting a failed service.  attempt counts the
+// consecutive failures of the service, starting at 1, and lastUptime is how long the failed run
+// was ready for.
+type Backoff interface {
+	Next(attempt int, lastUptime time.Duration) time.Duration
+}
+
+// ConstantBackoff waits the same time before every restart.
+type ConstantBackoff time.Duration
+
+// Next implements Backoff.
+func (b ConstantBackoff) Next(attempt int, lastUptime time.Duration) time.Duration {
+	return time.Duration(b)
+}
+
+// Jitter randomizes backoff delays, so that a fleet of processes failing together does not
+// restart in lockstep.
+type Jitter int
+
+const (
+	JitterNone         Jitter = iota // Deterministic delays.
+	JitterFull                       // Uniform between zero and the delay.
+	JitterEqual                      // Half the delay, plus uniform up to the other half.
+	JitterDecorrelated               // Uniform between Initial and three times the prior delay.
+)
+
+// ExponentialBackoff multiplies the wait before each consecutive restart.
+type ExponentialBackoff struct {
+	Initial    time.Duration // Wait before the first restart.
+	Max        time.Duration // Limit on the wait, zero for none.
+	Multiplier float64       // Growth per attempt, defaults to 2.
+	Reset      time.Duration // After running this long, a failure is treated as the first.
+	Jitter     Jitter
+}
+
+// Next implements Backoff.
+func (b ExponentialBackoff) Next(attempt int, lastUptime time.Duration) time.Duration {
+	if b.Reset > 0 && lastUptime >= b.Reset {
+		attempt = 1
+	}
+	d := b.delay(attempt)
+	switch b.Jitter {
+	case JitterFull:
+		d = randDuration(0, d)
+	case JitterEqual:
+		d = d/2 + randDuration(0, d-d/2)
+	case JitterDecorrelated:
+		// Next is stateless, so the prior delay is taken to be the unjittered one.
+		prior := b.Initial
+		if attempt > 1 {
+			prior = b.delay(attempt - 1)
+		}
+		d = randDuration(b.Initial, b.limit(3*float64(prior)))
+	}
+	return d
+}
+
+// delay returns the unjittered wait before attempt.
+func (b ExponentialBackoff) delay(attempt int) time.Duration {
+	m := b.Multiplier
+	if m <= 0 {
+		m = 2
+	}
+	return b.limit(float64(b.Initial) * math.Pow(m, float64(max(attempt-1, 0))))
+}
+
+// limit converts d to a Duration no longer than Max.
+func (b ExponentialBackoff) limit(d float64) time.Duration {
+	if b.Max > 0 && d > float64(b.Max) {
+		return b.Max
+	}
+	if d > math.MaxInt64 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(d)
+}
+
+// randDuration returns a random duration in [lo, hi].
+func randDuration(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + rand.N(hi-lo+1)
+}
+
+// backoffFor returns the Backoff of svc, from WithBackoff or its RestartPolicy, falling back to
+// the supervisor's defaults in the same order.
+func (s *Supervisor) backoffFor(svc *Service) Backoff {
+	switch {
+	case svc.backoff != nil:
+		return svc.backoff
+	case svc.policy != nil:
+		return ConstantBackoff(svc.policy.Delay)
+	case s.backoff != nil:
+		return s.backoff
+	case s.policy != nil:
+		return ConstantBackoff(s.policy.Delay)
+	}
+	return ConstantBackoff(0)
+}
+
+// policyFor returns the RestartPolicy of svc, or the supervisor's default, nil if neither is set.
+func (s *Supervisor) policyFor(svc *Service) *RestartPolicy {
+	if svc.policy != nil {
+		return svc.policy
+	}
+	return s.policy
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBucketEmpty is the cause passed up by Run when a RestartBucket has no restarts left.
+var ErrBucketEmpty = errors.New("shared restart bucket empty")
+
+// RestartBucket is a token bucket restart budget shared by a group of services, see
+// WithRestartBucket.  It stops a cascading failure, e.g. a database outage crashing every
+// consumer, from multiplying restart attempts.  Once empty the failure escalates: Run shuts down
+// and returns an error, for a parent supervisor or orchestrator to handle.
+type RestartBucket struct {
+	capacity int
+	refill   time.Duration
+
+	mu     sync.Mutex // Protects fields below.
+	tokens int
+	last   time.Time // When tokens was last refilled.
+}
+
+// NewRestartBucket creates a full RestartBucket allowing capacity restarts at once, and one more
+// restart every refill thereafter.  A zero refill never refills.
+func NewRestartBucket(capacity int, refill time.Duration) *RestartBucket {
+	return &RestartBucket{
+		capacity: capacity,
+		refill:   refill,
+		tokens:   capacity,
+		last:     time.Now(),
+	}
+}
+
+// Tokens returns the restarts currently available.
+func (b *RestartBucket) Tokens() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(time.Now())
+	return b.tokens
+}
+
+// take uses a restart, reporting false if none are available.
+func (b *RestartBucket) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(time.Now())
+	if b.tokens <= 0 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// fill adds tokens accrued since last, must be called with mu held.
+func (b *RestartBucket) fill(now time.Time) {
+	if b.refill <= 0 {
+		return
+	}
+	n := int(now.Sub(b.last) / b.refill)
+	if n <= 0 {
+		return
+	}
+	b.tokens = min(b.tokens+n, b.capacity)
+	b.last = b.last.Add(time.Duration(n) * b.refill)
+	if b.tokens == b.capacity {
+		b.last = now
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrChaosKilled is the failure of a service killed by a Chaos controller.
+var ErrChaosKilled = errors.New("killed by chaos")
+
+// ChaosOptions configures the faults injected by a Chaos controller.  Zero values disable each
+// kind of fault.
+type ChaosOptions struct {
+	Seed       uint64        // Seeds the schedule so a run can be reproduced, random if zero.
+	KillEvery  time.Duration // Mean interval between kills of a random running service.
+	StartDelay time.Duration // Upper bound of random delays to starting services.
+	StopDelay  time.Duration // Upper bound of random delays to the exit of stopping services.
+	Rate       float64       // Probability each start or stop is delayed, 0 to 1.
+}
+
+// Chaos randomly kills, delays the start of, or blocks the shutdown of selected services, to
+// exercise restart policies and timeouts, see WithChaos.
+type Chaos struct {
+	sel  Selector
+	opts ChaosOptions
+	mu   sync.Mutex
+	rng  *rand.Rand
+	runs map[*Service]context.CancelCauseFunc // Running services that may be killed.
+}
+
+// NewChaos creates a Chaos controller injecting faults into services chosen by sel, or all
+// services if sel is nil.
+func NewChaos(sel Selector, opts ChaosOptions) *Chaos {
+	if sel == nil {
+		sel = func(*Service) bool { return true }
+	}
+	if opts.Seed == 0 {
+		opts.Seed = rand.Uint64()
+	}
+	return &Chaos{
+		sel:  sel,
+		opts: opts,
+		rng:  rand.New(rand.NewPCG(opts.Seed, opts.Seed)),
+		runs: make(map[*Service]context.CancelCauseFunc),
+	}
+}
+
+// Seed returns the seed of the schedule, to reproduce it.
+func (c *Chaos) Seed() uint64 {
+	return c.opts.Seed
+}
+
+// roll returns a random duration up to max with probability Rate, otherwise zero.
+func (c *Chaos) roll(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rng.Float64() >= c.opts.Rate {
+		return 0
+	}
+	return time.Duration(c.rng.Int64N(int64(max) + 1))
+}
+
+// delayStart sleeps for a random start delay of svc, returning early if ctx is done.
+func (c *Chaos) delayStart(ctx context.Context, svc *Service) {
+	if !c.sel(svc) {
+		return
+	}
+	d := c.roll(c.opts.StartDelay)
+	if d <= 0 {
+		return
+	}
+	LoggerFromContext(ctx).Warn("chaos delaying start", "service", svc.Name(), "delay", d)
+	sleepCtx(ctx, d)
+}
+
+// middleware registers runs of selected services to be killed, and delays their exit once they
+// are stopped.
+func (c *Chaos) middleware(r Runner) Runner {
+	return RunFunc(func(ctx context.Context) error {
+		svc := FromContext(ctx)
+		if svc == nil || !c.sel(svc) {
+			return r.Run(ctx)
+		}
+		rctx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+		c.mu.Lock()
+		c.runs[svc] = cancel
+		c.mu.Unlock()
+		err := r.Run(rctx)
+		c.mu.Lock()
+		delete(c.runs, svc)
+		c.mu.Unlock()
+		if ctx.Err() == nil && errors.Is(context.Cause(rctx), ErrChaosKilled) {
+			return ErrChaosKilled
+		}
+		if ctx.Err() != nil {
+			if d := c.roll(c.opts.StopDelay); d > 0 {
+				LoggerFromContext(ctx).Warn("chaos blocking stop", "service", svc.Name(), "delay", d)
+				time.Sleep(d)
+			}
+		}
+		return err
+	})
+}
+
+// run kills a random running service about every KillEvery, until ctx is done.
+func (c *Chaos) run(ctx context.Context, s *Supervisor) {
+	if c.opts.KillEvery <= 0 {
+		return
+	}
+	for {
+		c.mu.Lock()
+		d := time.Duration(c.rng.Int64N(2*int64(c.opts.KillEvery) + 1))
+		c.mu.Unlock()
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return
+		}
+		c.mu.Lock()
+		victims := make([]*Service, 0, len(c.runs))
+		for svc := range c.runs {
+			victims = append(victims, svc)
+		}
+		// Sorted so the seed reproduces the choice.
+		slices.SortFunc(victims, func(a, b *Service) int { return cmp.Compare(a.name, b.name) })
+		if len(victims) > 0 {
+			victim := victims[c.rng.IntN(len(victims))]
+			s.loggerFor(victim).Warn("chaos killing service", "service", victim.Name())
+			c.runs[victim](ErrChaosKilled)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// ErrInjected is the failure of a run injected by Faults.
+var ErrInjected = errors.New("injected fault")
+
+// FaultOptions configures the faults injected into each run by Faults.  Zero values disable
+// each kind of fault.
+type FaultOptions struct {
+	Seed         uint64        // Seeds the faults so a test can be reproduced, random if zero.
+	StartLatency time.Duration // Delay before calling Run.
+	ErrorRate    float64       // Probability each run fails with ErrInjected, 0 to 1.
+	ErrorAfter   time.Duration // Upper bound of the random time a failing run lasts.
+	StopLatency  time.Duration // Delay to the exit of each run, once stopped.
+}
+
+// Faults returns Middleware injecting latency, errors and slow shutdowns into every run of the
+// services it wraps, for exercising restart policies and timeouts in integration tests.
+func Faults(opts FaultOptions) Middleware {
+	if opts.Seed == 0 {
+		opts.Seed = rand.Uint64()
+	}
+	var mu sync.Mutex
+	rng := rand.New(rand.NewPCG(opts.Seed, opts.Seed))
+	return func(r Runner) Runner {
+		return RunFunc(func(ctx context.Context) error {
+			if !sleepCtx(ctx, opts.StartLatency) {
+				return nil
+			}
+			mu.Lock()
+			fail := rng.Float64() < opts.ErrorRate
+			after := time.Duration(rng.Int64N(int64(max(opts.ErrorAfter, 0)) + 1))
+			mu.Unlock()
+			rctx, cancel := context.WithCancelCause(ctx)
+			defer cancel(nil)
+			if fail {
+				t := time.AfterFunc(after, func() { cancel(ErrInjected) })
+				defer t.Stop()
+			}
+			err := r.Run(rctx)
+			if ctx.Err() == nil && errors.Is(context.Cause(rctx), ErrInjected) {
+				return ErrInjected
+			}
+			if ctx.Err() != nil {
+				time.Sleep(opts.StopLatency)
+			}
+			return err
+		})
+	}
+}
+
+// sleepCtx sleeps for d, reporting false if ctx was done first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ReasonDependency is the reason the children of a Sequence or Parallel are stopped when another
+// of them fails.
+const ReasonDependency StopReason = "dependency"
+
+// composite is the Runner of a Sequence or Parallel, running child services as a single unit.
+type composite struct {
+	children []*Service
+	parallel bool
+}
+
+// Sequence creates a service running children one after another, presenting them to the
+// supervisor as a single unit, e.g. to migrate a database and then serve.  Each child must
+// become ready, or exit cleanly, before the next is started; the sequence is ready once they all
+// have.  Children other than the last without a Starter are ready immediately, so they are
+// instead run to completion.  If any child fails the others are stopped and the sequence fails.
+// Children are stopped in reverse order.  The sequence itself is configured by opts, e.g.
+// WithRequires or WithShutdownRank.
+func Sequence(name string, children []*Service, opts ...Option) *Service {
+	return New(name, append([]Option{WithRunner(&composite{children: children})}, opts...)...)
+}
+
+// Parallel creates a service running children concurrently, presenting them to the supervisor as
+// a single unit, e.g. for tightly coupled goroutines.  It is ready once all of the children are
+// ready.  If any child fails the others are stopped and the parallel service fails.  The
+// parallel service itself is configured by opts, as for Sequence.
+func Parallel(name string, children []*Service, opts ...Option) *Service {
+	r := &composite{children: children, parallel: true}
+	return New(name, append([]Option{WithRunner(r)}, opts...)...)
+}
+
+// Validate implements Validator, checking the configuration of all the children.
+func (c *composite) Validate() error {
+	var errs []error
+	for _, child := range c.children {
+		if err := child.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start implements Starter, starting the children.  ctx only bounds waiting for them to become
+// ready, as it is canceled once Start returns; the children are stopped by Run instead.
+func (c *composite) Start(ctx context.Context) error {
+	if c.parallel {
+		return c.startParallel(ctx)
+	}
+	for i, child := range c.children {
+		child.Start(context.WithoutCancel(ctx))
+		ready := child.Ready()
+		if _, ok := child.instance().(Starter); !ok && i < len(c.children)-1 {
+			// Run to completion.
+			ready = nil
+		}
+		select {
+		case <-ready:
+		case <-child.exited():
+			if err := child.Err(); err != nil {
+				c.stop(i, ReasonDependency)
+				return err
+			}
+		case <-ctx.Done():
+			c.stop(i, ReasonShutdown)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// startParallel starts all of the children, waiting for them to become ready, as for Start.
+func (c *composite) startParallel(ctx context.Context) error {
+	for _, child := range c.children {
+		child.Start(context.WithoutCancel(ctx))
+	}
+	for _, child := range c.children {
+		select {
+		case <-child.Ready():
+		case <-child.exited():
+			if err := child.Err(); err != nil {
+				c.stop(len(c.children)-1, ReasonDependency)
+				return err
+			}
+		case <-ctx.Done():
+			c.stop(len(c.children)-1, ReasonShutdown)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Run implements Runner, waiting for the children to exit or a stop to be requested.
+func (c *composite) Run(ctx context.Context) error {
+	exitc := make(chan *Service, len(c.children))
+	for _, child := range c.children {
+		go func() {
+			<-child.exited()
+			exitc <- child
+		}()
+	}
+	for range c.children {
+		select {
+		case child := <-exitc:
+			if err := child.Err(); err != nil {
+				c.stop(len(c.children)-1, ReasonDependency)
+				return fmt.Errorf("child %w", err)
+			}
+		case <-ctx.Done():
+			c.stop(len(c.children)-1, ReasonShutdown)
+			return nil
+		}
+	}
+	return nil
+}
+
+// stop stops children up to and including the last, waiting for them to exit.  A sequence stops
+// them in reverse order, waiting for each in turn.
+func (c *composite) stop(last int, reason StopReason) {
+	if c.parallel {
+		for _, child := range c.children[:last+1] {
+			child.StopWithReason(reason)
+		}
+	}
+	for i := last; i >= 0; i-- {
+		child := c.children[i]
+		child.StopWithReason(reason)
+		<-child.exited()
+	}
+}
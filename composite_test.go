@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// blocker is a Runner which is ready once started, and runs until it is stopped.
+type blocker struct{}
+
+func (blocker) Start(ctx context.Context) error { return nil }
+
+func (blocker) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func TestSequenceStartTimeout(t *testing.T) {
+	testStartTimeout(t, Sequence)
+}
+
+func TestParallelStartTimeout(t *testing.T) {
+	testStartTimeout(t, Parallel)
+}
+
+// testStartTimeout checks the children of a composite with a start timeout run until it stops.
+func testStartTimeout(t *testing.T, compose func(string, []*Service, ...Option) *Service) {
+	a, b := New("a", WithRunner(blocker{})), New("b", WithRunner(blocker{}))
+	seq := compose("seq", []*Service{a, b}, WithStartTimeout(time.Second))
+	seq.Start(context.Background())
+	<-seq.Ready()
+	time.Sleep(50 * time.Millisecond)
+	for _, svc := range []*Service{a, b, seq} {
+		select {
+		case <-svc.exited():
+			t.Fatalf("%s exited after starting: %v", svc.Name(), svc.Err())
+		default:
+		}
+	}
+	seq.Stop()
+	<-seq.exited()
+	for _, svc := range []*Service{a, b} {
+		select {
+		case <-svc.exited():
+		default:
+			t.Errorf("%s not stopped with its parent", svc.Name())
+		}
+	}
+}
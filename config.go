@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// ConfigSource loads configuration values of type T, e.g. from a file, the environment or a
+// remote store.
+type ConfigSource[T any] interface {
+	Load(ctx context.Context) (T, error)
+}
+
+// ConfigSourceFunc adapts an ordinary function to the ConfigSource interface.
+type ConfigSourceFunc[T any] func(ctx context.Context) (T, error)
+
+// Load calls f(ctx).
+func (f ConfigSourceFunc[T]) Load(ctx context.Context) (T, error) {
+	return f(ctx)
+}
+
+// FileSource returns a ConfigSource reading path, converting its contents with parse.
+func FileSource[T any](path string, parse func([]byte) (T, error)) ConfigSource[T] {
+	return ConfigSourceFunc[T](func(ctx context.Context) (T, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			var zero T
+			return zero, err
+		}
+		return parse(b)
+	})
+}
+
+// ConfigApplier may be implemented by a Runner to apply new configuration without restarting.
+type ConfigApplier[T any] interface {
+	ApplyConfig(ctx context.Context, cfg T) error
+}
+
+// Config holds the current configuration of type T, pushing updates to the services consuming
+// it.  Runners should read their configuration with Get when they start.
+type Config[T any] struct {
+	sup   *Supervisor
+	names []string
+
+	mu      sync.RWMutex // Protects current.
+	current T
+}
+
+// NewConfig creates a Config holding initial, consumed by the named services of sup.
+func NewConfig[T any](sup *Supervisor, initial T, names ...string) *Config[T] {
+	return &Config[T]{sup: sup, names: names, current: initial}
+}
+
+// Get returns the current configuration.
+func (c *Config[T]) Get() T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+// Update makes cfg current, and pushes it to the consuming services.  Services implementing
+// ConfigApplier[T] are sent cfg with ApplyConfig, all others, or those failing to apply it, are
+// restarted with ReasonConfig.
+func (c *Config[T]) Update(ctx context.Context, cfg T) error {
+	c.mu.Lock()
+	c.current = cfg
+	c.mu.Unlock()
+	var errs []error
+	for _, name := range c.names {
+		svc := c.sup.service(name)
+		if svc == nil {
+			errs = append(errs, fmt.Errorf("unknown service %q", name))
+			continue
+		}
+		if ca, ok := svc.instance().(ConfigApplier[T]); ok {
+			err := ca.ApplyConfig(ctx, cfg)
+			if err == nil {
+				continue
+			}
+			c.sup.loggerFor(svc).Warn("failed to apply config, restarting", "service", name,
+				"err", err)
+		}
+		if err := c.sup.Restart(name, ReasonConfig); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	c.sup.Reconcile()
+	return errors.Join(errs...)
+}
+
+// Reload loads the configuration from src, and then calls Update.
+func (c *Config[T]) Reload(ctx context.Context, src ConfigSource[T]) error {
+	cfg, err := src.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	return c.Update(ctx, cfg)
+}
+
+// ReloadOnSignal calls Reload each time one of sigs, e.g. SIGHUP, is received, until ctx is done.
+// Reload errors are logged, leaving the previous configuration in effect.
+func (c *Config[T]) ReloadOnSignal(ctx context.Context, src ConfigSource[T], sigs ...os.Signal) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, sigs...)
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case sig := <-sigc:
+			c.sup.logger.Info("reloading config", "signal", sig)
+			if err := c.Reload(ctx, src); err != nil {
+				c.sup.logger.Error("failed to reload config", "err", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
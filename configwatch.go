@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ConfigWatcher is a Runner that polls a configuration file for changes, parsing it and feeding
+// the result to a Config.  Changes are debounced, so editors writing a file several times in
+// quick succession cause a single reload.
+type ConfigWatcher[T any] struct {
+	cfg      *Config[T]
+	path     string
+	parse    func([]byte) (T, error)
+	interval time.Duration
+	debounce time.Duration
+}
+
+// NewConfigWatcher creates a ConfigWatcher checking path every interval, and updating cfg once
+// the file has been unchanged for debounce.
+func NewConfigWatcher[T any](cfg *Config[T], path string, parse func([]byte) (T, error),
+	interval, debounce time.Duration) *ConfigWatcher[T] {
+	return &ConfigWatcher[T]{
+		cfg:      cfg,
+		path:     path,
+		parse:    parse,
+		interval: interval,
+		debounce: debounce,
+	}
+}
+
+// Validate implements Validator, checking the polling interval is positive.
+func (w *ConfigWatcher[T]) Validate() error {
+	if w.interval <= 0 {
+		return fmt.Errorf("non-positive config polling interval %v", w.interval)
+	}
+	return nil
+}
+
+// Run implements Runner.
+func (w *ConfigWatcher[T]) Run(ctx context.Context) error {
+	// The file is assumed to have been loaded before we start, so only later changes are applied.
+	applied, _ := os.ReadFile(w.path)
+	last, _ := os.Stat(w.path)
+	var changed time.Time
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		fi, err := os.Stat(w.path)
+		if err != nil {
+			// Possibly mid-rename by an editor, try again next tick.
+			continue
+		}
+		if last == nil || fi.ModTime() != last.ModTime() || fi.Size() != last.Size() {
+			last, changed = fi, time.Now()
+			continue
+		}
+		if changed.IsZero() || time.Since(changed) < w.debounce {
+			continue
+		}
+		changed = time.Time{}
+		b, err := os.ReadFile(w.path)
+		if err != nil || bytes.Equal(b, applied) {
+			continue
+		}
+		cfg, err := w.parse(b)
+		if err != nil {
+			w.cfg.sup.logger.Error("config not reloaded", "path", w.path, "err", err)
+			continue
+		}
+		applied = b
+		w.cfg.sup.logger.Info("config changed, reloading", "path", w.path)
+		if err := w.cfg.Update(ctx, cfg); err != nil {
+			w.cfg.sup.logger.Error("failed to update config", "path", w.path, "err", err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ConnTracker tracks connections accepted by a raw TCP or custom protocol service, so it can
+// drain gracefully: idle connections are closed as soon as draining begins, and active ones as
+// they become idle, until the drain deadline closes the rest.  Protocol handlers mark connections
+// idle between requests with TrackedConn.SetIdle.  A ConnTracker drains once, so create one for
+// each run, e.g. in Start.
+type ConnTracker struct {
+	mu       sync.Mutex
+	conns    map[*TrackedConn]struct{}
+	draining bool
+	empty    chan struct{} // Closed when draining and no connections remain.
+}
+
+// TrackedConn is a connection tracked by a ConnTracker.
+type TrackedConn struct {
+	net.Conn
+	t    *ConnTracker
+	idle bool // Protected by t.mu.
+	once sync.Once
+}
+
+// NewConnTracker creates a ConnTracker with no connections.
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{conns: make(map[*TrackedConn]struct{}), empty: make(chan struct{})}
+}
+
+// Listener returns ln, tracking the connections it accepts.  Accept fails with net.ErrClosed
+// once draining has begun.
+func (t *ConnTracker) Listener(ln net.Listener) net.Listener {
+	return &trackedListener{Listener: ln, t: t}
+}
+
+// trackedListener is a net.Listener tracking the connections it accepts.
+type trackedListener struct {
+	net.Listener
+	t *ConnTracker
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tc := l.t.Track(conn)
+	if tc == nil {
+		return nil, fmt.Errorf("accept: draining: %w", net.ErrClosed)
+	}
+	return tc, nil
+}
+
+// Track starts tracking conn, as active.  If draining has begun, conn is closed and Track returns
+// nil.
+func (t *ConnTracker) Track(conn net.Conn) *TrackedConn {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.draining {
+		conn.Close()
+		return nil
+	}
+	tc := &TrackedConn{Conn: conn, t: t}
+	t.conns[tc] = struct{}{}
+	return tc
+}
+
+// Len returns the number of open tracked connections.
+func (t *ConnTracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// Drain begins draining, closing idle connections, and waits for active connections to become
+// idle and close.  Once ctx is done the remaining connections are closed, and the number
+// abandoned is reported in the error.
+func (t *ConnTracker) Drain(ctx context.Context) error {
+	t.mu.Lock()
+	t.draining = true
+	var idle []*TrackedConn
+	for c := range t.conns {
+		if c.idle {
+			idle = append(idle, c)
+		}
+	}
+	t.checkEmpty()
+	t.mu.Unlock()
+	for _, c := range idle {
+		c.Close()
+	}
+	select {
+	case <-t.empty:
+		return nil
+	case <-ctx.Done():
+	}
+	t.mu.Lock()
+	active := make([]*TrackedConn, 0, len(t.conns))
+	for c := range t.conns {
+		active = append(active, c)
+	}
+	t.mu.Unlock()
+	for _, c := range active {
+		c.Close()
+	}
+	if len(active) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d active connections closed: %w", len(active), context.Cause(ctx))
+}
+
+// checkEmpty closes empty once draining with no connections.  It must be called with mu held.
+func (t *ConnTracker) checkEmpty() {
+	if t.draining && len(t.conns) == 0 {
+		select {
+		case <-t.empty:
+		default:
+			close(t.empty)
+		}
+	}
+}
+
+// SetIdle marks the connection idle, between requests, or active.  An idle connection is closed
+// while draining.
+func (c *TrackedConn) SetIdle(idle bool) {
+	c.t.mu.Lock()
+	c.idle = idle
+	closing := idle && c.t.draining
+	c.t.mu.Unlock()
+	if closing {
+		c.Close()
+	}
+}
+
+// Close closes the connection, and stops tracking it.
+func (c *TrackedConn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		err = c.Conn.Close()
+		c.t.mu.Lock()
+		delete(c.t.conns, c)
+		c.t.checkEmpty()
+		c.t.mu.Unlock()
+	})
+	return err
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ANSI terminal colors used by ConsoleHandler.
+const (
+	colorReset  = "\x1b[0m"
+	colorDim    = "\x1b[2m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// ConsoleHandler is a slog.Handler for reading logs in a terminal during local development, where
+// structured JSON is unreadable.  Service names are aligned, states and levels are colored, and
+// durations are compact.
+type ConsoleHandler struct {
+	out   *consoleOutput
+	level slog.Leveler
+	attrs []slog.Attr
+	group string // Prefix for attribute keys, from WithGroup.
+}
+
+// consoleOutput is shared by a ConsoleHandler and those derived from it.
+type consoleOutput struct {
+	mu    sync.Mutex
+	w     io.Writer
+	width int // Longest service name seen, for alignment.
+}
+
+// NewConsoleHandler creates a ConsoleHandler writing to w, at opts.Level or above if opts is not
+// nil.
+func NewConsoleHandler(w io.Writer, opts *slog.HandlerOptions) *ConsoleHandler {
+	h := &ConsoleHandler{out: &consoleOutput{w: w}, level: slog.LevelInfo}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h
+}
+
+// Enabled implements slog.Handler.
+func (h *ConsoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// WithAttrs implements slog.Handler.
+func (h *ConsoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], h.prefixed(attrs)...)
+	return &c
+}
+
+// WithGroup implements slog.Handler.
+func (h *ConsoleHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.group = h.group + name + "."
+	return &c
+}
+
+// Handle implements slog.Handler.
+func (h *ConsoleHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := h.attrs
+	var extra []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		extra = append(extra, a)
+		return true
+	})
+	attrs = append(attrs[:len(attrs):len(attrs)], h.prefixed(extra)...)
+	service := ""
+	var b strings.Builder
+	for _, a := range attrs {
+		if a.Key == "service" && service == "" {
+			service = a.Value.String()
+			continue
+		}
+		fmt.Fprintf(&b, " %s%s=%s", colorDim, a.Key, colorReset)
+		b.WriteString(consoleValue(a.Key, a.Value))
+	}
+
+	h.out.mu.Lock()
+	defer h.out.mu.Unlock()
+	h.out.width = max(h.out.width, len(service))
+	ts := r.Time
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	_, err := fmt.Fprintf(h.out.w, "%s%s%s %s %s%-*s%s %s%s\n",
+		colorDim, ts.Format("15:04:05.000"), colorReset,
+		consoleLevel(r.Level),
+		colorCyan, h.out.width, service, colorReset,
+		consoleMessage(r.Message), b.String())
+	return err
+}
+
+// prefixed returns attrs with keys prefixed by our group.
+func (h *ConsoleHandler) prefixed(attrs []slog.Attr) []slog.Attr {
+	if h.group == "" {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = slog.Attr{Key: h.group + a.Key, Value: a.Value}
+	}
+	return out
+}
+
+// consoleLevel returns level colored and padded for alignment.
+func consoleLevel(level slog.Level) string {
+	color := ""
+	switch {
+	case level >= slog.LevelError:
+		color = colorRed
+	case level >= slog.LevelWarn:
+		color = colorYellow
+	case level < slog.LevelInfo:
+		color = colorDim
+	}
+	return fmt.Sprintf("%s%-5s%s", color, level, colorReset)
+}
+
+// consoleMessage colors msg by the lifecycle transition it describes.
+func consoleMessage(msg string) string {
+	switch {
+	case strings.Contains(msg, "fail"), strings.Contains(msg, "abandon"):
+		return colorRed + msg + colorReset
+	case strings.Contains(msg, "restart"), strings.Contains(msg, "stopping"):
+		return colorYellow + msg + colorReset
+	case strings.Contains(msg, "started"), strings.Contains(msg, "running"):
+		return colorGreen + msg + colorReset
+	}
+	return msg
+}
+
+// stateColors are the colors of State values.
+var stateColors = map[State]string{
+	StateRunning:  colorGreen,
+	StateStarting: colorYellow,
+	StateStopping: colorYellow,
+	StatePaused:   colorYellow,
+	StateFailed:   colorRed,
+}
+
+// consoleValue formats v compactly, coloring states and errors.
+func consoleValue(key string, v slog.Value) string {
+	v = v.Resolve()
+	switch v.Kind() {
+	case slog.KindDuration:
+		return compactDuration(v.Duration())
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case State:
+			if color, ok := stateColors[x]; ok {
+				return color + string(x) + colorReset
+			}
+		case error:
+			return colorRed + fmt.Sprintf("%q", x.Error()) + colorReset
+		}
+	}
+	s := v.String()
+	if key == "err" {
+		return colorRed + fmt.Sprintf("%q", s) + colorReset
+	}
+	if strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// compactDuration formats d to about three significant figures, e.g. 1.25s or 340ms.
+func compactDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour:
+		return d.Round(time.Minute).String()
+	case d >= time.Minute:
+		return d.Round(time.Second).String()
+	case d >= time.Second:
+		return d.Round(time.Millisecond * 10).String()
+	case d >= time.Millisecond:
+		return d.Round(time.Millisecond).String()
+	}
+	return d.String()
+}
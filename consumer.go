@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Consumer is a message queue client, e.g. for Kafka, NATS or SQS, consumed by a ConsumerRunner.
+type Consumer[M any] interface {
+	// Fetch waits for the next batch of messages, returning when ctx is done.
+	Fetch(ctx context.Context) ([]M, error)
+	// Process handles a message.
+	Process(ctx context.Context, msg M) error
+	// Commit acknowledges a batch of processed messages, e.g. by committing their offsets.
+	Commit(ctx context.Context, msgs []M) error
+}
+
+// ConsumerRunner is a Runner consuming batches of messages from a Consumer, processing each
+// batch and then committing it.  When stopped it drains: fetching stops, the messages in flight
+// are processed and committed, and it exits, so no processed message is redelivered.  Processing
+// while draining is bounded by the service's stop timeout.
+type ConsumerRunner[M any] struct {
+	c       Consumer[M]
+	workers int
+}
+
+// NewConsumerRunner creates a ConsumerRunner processing up to workers messages of a batch at
+// once, or one at a time if workers is less than 2.
+func NewConsumerRunner[M any](c Consumer[M], workers int) *ConsumerRunner[M] {
+	return &ConsumerRunner[M]{c: c, workers: max(workers, 1)}
+}
+
+// Run implements Runner, consuming messages until ctx is done, and then draining.  A batch with
+// a message that fails to process is not committed, and fails the run.
+func (r *ConsumerRunner[M]) Run(ctx context.Context) error {
+	// Messages fetched are finished, rather than abandoned when ctx is done.
+	wctx := context.WithoutCancel(ctx)
+	for ctx.Err() == nil {
+		msgs, err := r.c.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return fmt.Errorf("fetch: %w", err)
+		}
+		if len(msgs) == 0 {
+			continue
+		}
+		if err := r.process(wctx, msgs); err != nil {
+			return fmt.Errorf("process: %w", err)
+		}
+		if err := r.c.Commit(wctx, msgs); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+	}
+	LoggerFromContext(ctx).Debug("consumer drained")
+	return nil
+}
+
+// process processes msgs, up to workers at once, returning the errors of those that failed.
+func (r *ConsumerRunner[M]) process(ctx context.Context, msgs []M) error {
+	if r.workers == 1 {
+		for _, msg := range msgs {
+			if err := r.c.Process(ctx, msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	sem := make(chan struct{}, r.workers)
+	for _, msg := range msgs {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := r.c.Process(ctx, msg); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	crashLoopHistory = 10 // Errors and restarts of each service kept for CrashLoop.
+	eventHistory     = 50 // Events kept for CrashLoop.
+)
+
+// CrashLoop describes a service whose restart policy breaker has opened, see WithCrashLoopHook.
+type CrashLoop struct {
+	Service  string
+	Time     time.Time
+	Errors   []error     // The most recent failures of the service, oldest first.
+	Restarts []time.Time // When the service was most recently restarted after failing.
+	Events   []Event     // The most recent events of the supervisor, for all services.
+}
+
+// crashLoop calls the crash loop hooks for svc.
+func (r *runState) crashLoop(svc *Service) {
+	if len(r.sup.crashHooks) == 0 {
+		return
+	}
+	r.sup.recentMu.Lock()
+	events := slices.Clone(r.sup.recent)
+	r.sup.recentMu.Unlock()
+	cl := CrashLoop{
+		Service:  svc.Name(),
+		Time:     time.Now(),
+		Errors:   slices.Clone(r.errors[svc]),
+		Restarts: slices.Clone(r.restarts[svc]),
+		Events:   events,
+	}
+	for _, hook := range r.sup.crashHooks {
+		go hook(cl)
+	}
+}
+
+// lastN returns the last n elements of s.
+func lastN[T any](s []T, n int) []T {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
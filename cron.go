@@ -0,0 +1,24 @@
+package main
+
+import "context"
+
+// Scheduler is a job scheduler started in the background, and stopped with a context that is
+// done once its running jobs have finished, e.g. a *cron.Cron of robfig/cron.
+type Scheduler interface {
+	Start()
+	Stop() context.Context
+}
+
+// RunScheduler adapts sched to the Runner interface, starting it and running until ctx is done.
+// It then stops sched, and waits for its running jobs to finish, bounded by the service's stop
+// timeout, so jobs are not cut off by shutdown.
+func RunScheduler(sched Scheduler) Runner {
+	return RunFunc(func(ctx context.Context) error {
+		sched.Start()
+		<-ctx.Done()
+		jobs := sched.Stop()
+		LoggerFromContext(ctx).Debug("waiting for scheduled jobs to finish")
+		<-jobs.Done()
+		return nil
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DependencyCycleError reports services that require each other, which could never be started.
+type DependencyCycleError struct {
+	Cycle []string // Names of the services, starting and ending with the same one.
+}
+
+func (e *DependencyCycleError) Error() string {
+	return "dependency cycle " + strings.Join(e.Cycle, " → ")
+}
+
+// requirements returns the registered services svc requires, resolving each name it requires to
+// the services with that name, replicas of that name, or services providing it.
+func (s *Supervisor) requirements(svc *Service) ([]*Service, error) {
+	return s.resolve(svc, svc.requires, true)
+}
+
+// soft returns the registered services svc wants, ignoring names that are not registered.
+func (s *Supervisor) soft(svc *Service) []*Service {
+	deps, _ := s.resolve(svc, svc.wants, false)
+	return deps
+}
+
+// resolve returns the registered services named by names, as for requirements, reporting names
+// that are not registered if strict.
+func (s *Supervisor) resolve(svc *Service, names []string, strict bool) ([]*Service, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	svcs := s.list()
+	var deps []*Service
+	var errs []error
+	for _, name := range names {
+		found := false
+		for _, dep := range svcs {
+			if dep.name != name && dep.Group() != name && !slices.Contains(dep.provides, name) {
+				continue
+			}
+			found = true
+			if dep.Group() == svc.Group() {
+				errs = append(errs, fmt.Errorf("service %q requires itself", svc.name))
+				continue
+			}
+			if !slices.Contains(deps, dep) {
+				deps = append(deps, dep)
+			}
+		}
+		if !found && strict {
+			errs = append(errs, fmt.Errorf("service %q requires unknown service %q", svc.name, name))
+		}
+	}
+	return deps, errors.Join(errs...)
+}
+
+// satisfied reports whether all the services svc requires are running, and ready, and none of
+// those it wants are still starting.
+func (r *runState) satisfied(svc *Service) bool {
+	deps, _ := r.sup.requirements(svc)
+	for _, dep := range deps {
+		if dep.Snapshot().State != StateRunning {
+			return false
+		}
+	}
+	for _, dep := range r.sup.soft(svc) {
+		if dep.Snapshot().State == StateStarting {
+			return false
+		}
+	}
+	return true
+}
+
+// unblock starts services that were waiting for their requirements, once another is ready or
+// has exited.
+func (r *runState) unblock() {
+	for blocked := range r.blocked {
+		if !r.paused[blocked] && !r.running[blocked] {
+			r.start(blocked)
+		}
+	}
+}
+
+// depth returns the length of the longest chain of requirements of svc, including those it
+// wants, so that it is started after and stopped before them.
+func (s *Supervisor) depth(svc *Service, visiting map[*Service]bool) int {
+	if visiting[svc] {
+		return 0
+	}
+	visiting[svc] = true
+	defer delete(visiting, svc)
+	deps, _ := s.requirements(svc)
+	depth := 0
+	for _, dep := range append(deps, s.soft(svc)...) {
+		depth = max(depth, s.depth(dep, visiting)+1)
+	}
+	return depth
+}
+
+// cycles reports each cycle of services requiring each other, found by depth first search.  Only
+// WithRequires forms cycles, WithWants never blocks a service that is not yet starting.
+func (s *Supervisor) cycles() error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[*Service]int)
+	var path []*Service
+	var errs []error
+	var visit func(svc *Service)
+	visit = func(svc *Service) {
+		marks[svc] = visiting
+		path = append(path, svc)
+		deps, _ := s.requirements(svc)
+		for _, dep := range deps {
+			switch marks[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				cycle := &DependencyCycleError{}
+				for _, svc := range path[slices.Index(path, dep):] {
+					cycle.Cycle = append(cycle.Cycle, svc.name)
+				}
+				cycle.Cycle = append(cycle.Cycle, dep.name)
+				errs = append(errs, cycle)
+			}
+		}
+		path = path[:len(path)-1]
+		marks[svc] = visited
+	}
+	for _, svc := range s.list() {
+		if marks[svc] == unvisited {
+			visit(svc)
+		}
+	}
+	return errors.Join(errs...)
+}
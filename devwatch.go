@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DevWatcher is a Runner for development, restarting services when files they are built from
+// change, like a lightweight live-reload runner.  Paths are polled, and changes are debounced so
+// saving several files at once causes a single restart.
+type DevWatcher struct {
+	sup      *Supervisor
+	sel      Selector
+	paths    []string
+	interval time.Duration
+	debounce time.Duration
+	build    []string // Command run before restarting, if set.
+}
+
+// NewDevWatcher creates a DevWatcher checking paths, files or directories searched recursively,
+// every interval.  Once they have been unchanged for debounce, the services matching sel, or
+// every other service if sel is nil, are gracefully restarted.
+func NewDevWatcher(sup *Supervisor, sel Selector, interval, debounce time.Duration,
+	paths ...string) *DevWatcher {
+	return &DevWatcher{
+		sup:      sup,
+		sel:      sel,
+		paths:    paths,
+		interval: interval,
+		debounce: debounce,
+	}
+}
+
+// Validate implements Validator, checking the polling interval is positive.
+func (w *DevWatcher) Validate() error {
+	if w.interval <= 0 {
+		return fmt.Errorf("non-positive file polling interval %v", w.interval)
+	}
+	return nil
+}
+
+// Build sets a command, e.g. go build ./..., run before each restart.  Services are only
+// restarted if it succeeds, and its output is attached to the EventReload.
+func (w *DevWatcher) Build(name string, args ...string) *DevWatcher {
+	w.build = append([]string{name}, args...)
+	return w
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+// Run implements Runner.
+func (w *DevWatcher) Run(ctx context.Context) error {
+	last := w.scan()
+	var changed time.Time
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		if current := w.scan(); !maps.Equal(current, last) {
+			last, changed = current, time.Now()
+			continue
+		}
+		if changed.IsZero() || time.Since(changed) < w.debounce {
+			continue
+		}
+		changed = time.Time{}
+		w.reload(ctx)
+	}
+}
+
+// reload runs the build command, if any, and restarts the selected services if it succeeds.
+func (w *DevWatcher) reload(ctx context.Context) {
+	ev := Event{Type: EventReload}
+	if self := FromContext(ctx); self != nil {
+		ev.Service = self.Name()
+	}
+	if len(w.build) > 0 {
+		w.sup.logger.Info("files changed, building", "command", strings.Join(w.build, " "))
+		out, err := exec.CommandContext(ctx, w.build[0], w.build[1:]...).CombinedOutput()
+		ev.Output = string(out)
+		if err != nil {
+			ev.Err = fmt.Errorf("build: %w", err)
+			w.sup.logger.Error("build failed, not restarting services", "err", err,
+				"output", ev.Output)
+			w.sup.emit(ev)
+			return
+		}
+	}
+	w.sup.logger.Info("files changed, restarting services")
+	w.sup.emit(ev)
+	w.restart(ctx)
+}
+
+// restart restarts the selected services, excluding the one running the watcher.
+func (w *DevWatcher) restart(ctx context.Context) {
+	self := FromContext(ctx)
+	for _, svc := range w.sup.list() {
+		if svc == self || (w.sel != nil && !w.sel(svc)) {
+			continue
+		}
+		if err := w.sup.Restart(svc.Name(), ReasonFileChange); err != nil {
+			w.sup.logger.Error("failed to restart service", "service", svc.Name(), "err", err)
+		}
+	}
+}
+
+// scan returns the current stamp of each file below our paths.  Unreadable files are skipped, as
+// they may be mid-save by an editor.
+func (w *DevWatcher) scan() map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, root := range w.paths {
+		_ = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if fi, err := d.Info(); err == nil {
+				stamps[path] = fileStamp{fi.ModTime(), fi.Size()}
+			}
+			return nil
+		})
+	}
+	return stamps
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// EventType identifies a service lifecycle transition.
+type EventType string
+
+const (
+	EventStarted          EventType = "started"
+	EventReady            EventType = "ready"
+	EventStopped          EventType = "stopped"
+	EventBreakerOpen      EventType = "breaker-open"
+	EventMemoryExceeded   EventType = "memory-exceeded"
+	EventReload           EventType = "reload"
+	EventShutdown         EventType = "shutdown-progress"
+	EventShutdownComplete EventType = "shutdown-complete"
+	EventLogLevel         EventType = "log-level"
+)
+
+// Event describes a service lifecycle transition.
+type Event struct {
+	Time    time.Time
+	Service string // Empty for supervisor events, e.g. EventShutdownComplete.
+	Type    EventType
+	Reason  StopReason    // Why the service stopped, set on EventStopped.
+	Err     error         // Final error of the service, set on EventStopped.
+	Attempt int           // Consecutive failures of the service, set on EventStopped if Err is.
+	Run     int           // Generation of the run, set on EventStarted, EventReady and EventStopped.
+	Usage   uint64        // Measured memory in bytes, set on EventMemoryExceeded.
+	Output  string        // Output of the build hook, set on EventReload.
+	Elapsed time.Duration // Time since shutdown began, set on EventShutdown(Complete).
+	Pending []string      // Services yet to exit, set on EventShutdown.
+	Audit   *Audit        // Requester of an operator restart, set on EventStopped.
+	Causes  []StopReason  // Reasons of the restart requests coalesced, set on EventStopped.
+	Level   slog.Level    // New log level, set on EventLogLevel.
+}
+
+func (e Event) String() string {
+	s := fmt.Sprintf("service %s %s", e.Service, e.Type)
+	if e.Service == "" {
+		s = fmt.Sprintf("supervisor %s", e.Type)
+	}
+	if e.Run > 0 {
+		s += fmt.Sprintf(" #%d", e.Run)
+	}
+	if len(e.Causes) > 1 {
+		s += fmt.Sprintf(" (%s)", joinReasons(e.Causes))
+	} else if e.Reason != ReasonNone {
+		s += fmt.Sprintf(" (%s)", e.Reason)
+	}
+	if e.Audit != nil {
+		s += " " + e.Audit.String()
+	}
+	if e.Usage > 0 {
+		s += fmt.Sprintf(" (%d bytes)", e.Usage)
+	}
+	if e.Type == EventLogLevel {
+		s += fmt.Sprintf(" %v", e.Level)
+	}
+	if e.Type == EventShutdown {
+		s = fmt.Sprintf("shutdown waiting on service %s after %v, %d pending", e.Service,
+			e.Elapsed.Round(time.Second), len(e.Pending))
+	}
+	if err := e.Err; err != nil {
+		// The service name and reason are already included.
+		if ee, ok := err.(*ExitError); ok {
+			err = ee.Err
+		}
+		s += fmt.Sprintf(": %v", err)
+	}
+	return s
+}
+
+// LifecycleEvent is one of StartedEvent, ReadyEvent, FailedEvent, StoppedEvent, or an Event of
+// any other type, for consumers to switch on exhaustively, see Event.Typed.
+type LifecycleEvent interface {
+	lifecycle()
+}
+
+// StartedEvent is an EventStarted, the service is starting.
+type StartedEvent struct {
+	Time    time.Time
+	Service string
+	Run     int
+}
+
+// ReadyEvent is an EventReady, the service is running and ready.
+type ReadyEvent struct {
+	Time    time.Time
+	Service string
+	Run     int
+}
+
+// FailedEvent is an EventStopped with an error, the service failed for the Attempt'th time in a
+// row.
+type FailedEvent struct {
+	Time    time.Time
+	Service string
+	Reason  StopReason
+	Err     error
+	Attempt int
+	Run     int
+}
+
+// StoppedEvent is an EventStopped without an error.
+type StoppedEvent struct {
+	Time    time.Time
+	Service string
+	Reason  StopReason
+	Audit   *Audit       // Requester of an operator restart.
+	Causes  []StopReason // Reasons of the restart requests coalesced.
+	Run     int
+}
+
+func (StartedEvent) lifecycle() {}
+func (ReadyEvent) lifecycle()   {}
+func (FailedEvent) lifecycle()  {}
+func (StoppedEvent) lifecycle() {}
+func (Event) lifecycle()        {}
+
+// Typed returns e as a StartedEvent, ReadyEvent, FailedEvent or StoppedEvent, or e itself for
+// other types of event.
+func (e Event) Typed() LifecycleEvent {
+	switch {
+	case e.Type == EventStarted:
+		return StartedEvent{Time: e.Time, Service: e.Service, Run: e.Run}
+	case e.Type == EventReady:
+		return ReadyEvent{Time: e.Time, Service: e.Service, Run: e.Run}
+	case e.Type == EventStopped && e.Err != nil:
+		return FailedEvent{Time: e.Time, Service: e.Service, Reason: e.Reason, Err: e.Err,
+			Attempt: e.Attempt, Run: e.Run}
+	case e.Type == EventStopped:
+		return StoppedEvent{Time: e.Time, Service: e.Service, Reason: e.Reason, Audit: e.Audit,
+			Causes: e.Causes, Run: e.Run}
+	}
+	return e
+}
+
+// joinReasons returns reasons separated by commas.
+func joinReasons(reasons []StopReason) string {
+	s := make([]string, len(reasons))
+	for i, r := range reasons {
+		s[i] = string(r)
+	}
+	return strings.Join(s, ", ")
+}
+
+// offer sends v on the buffered channel c without blocking, first dropping the oldest values
+// queued if it is full, so that a slow consumer sees the latest.  It returns the number dropped.
+func offer[T any](c chan T, v T) int {
+	dropped := 0
+	for {
+		select {
+		case c <- v:
+			return dropped
+		default:
+		}
+		select {
+		case <-c:
+			dropped++
+		default:
+			// Drained by the consumer meanwhile.
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// eventLogQueue is the number of events held for reporting before dropping.
+const eventLogQueue = 100
+
+// Event types of the Windows Event Log.
+const (
+	eventLogError       = 1
+	eventLogWarning     = 2
+	eventLogInformation = 4
+)
+
+// eventLogIDs are the event IDs reported for each type of event, so administrators can filter
+// on them.  Failures are reported as eventLogFailed.
+var eventLogIDs = map[EventType]uint32{
+	EventStarted:          1,
+	EventReady:            2,
+	EventStopped:          3,
+	EventBreakerOpen:      5,
+	EventShutdownComplete: 6,
+}
+
+// eventLogFailed is the event ID of a service failure.
+const eventLogFailed = 4
+
+// EventLog reports lifecycle events to the Windows Event Log, where Windows administrators look
+// for them.  Register Notify WithEventHook.  Events are queued and reported in the background.
+// Without a message file registered for the source, Event Viewer shows the message as an
+// insertion string of an unknown event ID.
+type EventLog struct {
+	src    eventSource
+	filter func(Event) bool
+	logger *slog.Logger
+	queue  chan Event
+	done   chan struct{}
+
+	mu      sync.Mutex // Protects dropped.
+	dropped int
+}
+
+// NewEventLog creates an EventLog reporting events matching filter as source, and starts
+// reporting them.  If filter is nil, EventStarted, EventStopped, EventBreakerOpen and
+// EventShutdownComplete are reported.  It fails on other platforms than Windows.
+func NewEventLog(source string, filter func(Event) bool) (*EventLog, error) {
+	src, err := openEventSource(source)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = func(ev Event) bool {
+			switch ev.Type {
+			case EventStarted, EventStopped, EventBreakerOpen, EventShutdownComplete:
+				return true
+			}
+			return false
+		}
+	}
+	l := &EventLog{
+		src:    src,
+		filter: filter,
+		logger: slog.Default(),
+		queue:  make(chan Event, eventLogQueue),
+		done:   make(chan struct{}),
+	}
+	go l.report()
+	return l, nil
+}
+
+// Notify queues ev to be reported if it matches our filter, dropping the oldest queued if full.
+func (l *EventLog) Notify(ev Event) {
+	if !l.filter(ev) {
+		return
+	}
+	if n := offer(l.queue, ev); n > 0 {
+		l.mu.Lock()
+		l.dropped += n
+		l.mu.Unlock()
+	}
+}
+
+// Dropped returns the number of events dropped, oldest first, because the queue was full.
+func (l *EventLog) Dropped() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.dropped
+}
+
+// Close stops accepting events, waiting for those queued to be reported until ctx is done.
+// Notify must not be called after Close.
+func (l *EventLog) Close(ctx context.Context) error {
+	close(l.queue)
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// report reports queued events until the queue is closed.
+func (l *EventLog) report() {
+	defer close(l.done)
+	defer l.src.close()
+	for ev := range l.queue {
+		kind, id := uint16(eventLogInformation), eventLogIDs[ev.Type]
+		switch {
+		case ev.Err != nil && ev.Service != "":
+			kind, id = eventLogError, eventLogFailed
+		case ev.Err != nil, ev.Type == EventBreakerOpen:
+			kind = eventLogError
+		case ev.Type == EventShutdown:
+			kind = eventLogWarning
+		}
+		if err := l.src.report(kind, id, ev.String()); err != nil {
+			l.logger.Error("event log report failed", "event", ev.Type, "err", err)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// eventSource is a Windows Event Log source, unavailable on this platform.
+type eventSource struct{}
+
+// openEventSource fails, the Windows Event Log is only available on Windows.
+func openEventSource(string) (eventSource, error) {
+	return eventSource{}, errors.New("event log: only available on Windows")
+}
+
+func (eventSource) report(uint16, uint32, string) error { return nil }
+
+func (eventSource) close() {}
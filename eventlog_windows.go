@@ -0,0 +1,54 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32                  = syscall.NewLazyDLL("advapi32.dll")
+	procRegisterEventSource   = advapi32.NewProc("RegisterEventSourceW")
+	procDeregisterEventSource = advapi32.NewProc("DeregisterEventSource")
+	procReportEvent           = advapi32.NewProc("ReportEventW")
+)
+
+// eventSource is a handle to a registered Windows Event Log source.
+type eventSource struct {
+	h uintptr
+}
+
+// openEventSource registers name as an event source on the local computer.
+func openEventSource(name string) (eventSource, error) {
+	p, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return eventSource{}, fmt.Errorf("event log: %w", err)
+	}
+	h, _, err := procRegisterEventSource.Call(0, uintptr(unsafe.Pointer(p)))
+	if h == 0 {
+		return eventSource{}, fmt.Errorf("event log: %w", err)
+	}
+	return eventSource{h: h}, nil
+}
+
+// report reports msg as an event of kind and id.
+func (s eventSource) report(kind uint16, id uint32, msg string) error {
+	p, err := syscall.UTF16PtrFromString(msg)
+	if err != nil {
+		return err
+	}
+	strs := []*uint16{p}
+	ok, _, err := procReportEvent.Call(s.h, uintptr(kind), 0, uintptr(id), 0, 1, 0,
+		uintptr(unsafe.Pointer(&strs[0])), 0)
+	if ok == 0 {
+		return err
+	}
+	return nil
+}
+
+// close deregisters the event source.
+func (s eventSource) close() {
+	procDeregisterEventSource.Call(s.h)
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// stopSignal is sent to a Process when its service is stopped.  Interrupt can not be sent on
+// this platform, so the process is killed.
+var stopSignal = os.Kill
+
+// execBinary is not supported on this platform.
+func execBinary(path string, args, env []string) error {
+	return errors.New("exec not supported on this platform")
+}
+
+// startWithUmask starts cmd, umask is not supported on this platform.
+func startWithUmask(cmd *exec.Cmd, mask os.FileMode) error {
+	return cmd.Start()
+}
@@ -0,0 +1,37 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// stopSignal is sent to a Process when its service is stopped.
+var stopSignal os.Signal = syscall.SIGTERM
+
+// execBinary replaces this process with path, only returning on failure.
+func execBinary(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
+
+// umaskShell starts commands needing a umask, see startWithUmask.
+const umaskShell = "/bin/sh"
+
+// startWithUmask starts cmd with mask as its umask.  The umask can only be set by the child
+// itself, so cmd is started by umaskShell, which sets it and then execs the command, leaving the
+// umask of our process untouched.  Images without a shell, e.g. distroless, can not set a umask.
+func startWithUmask(cmd *exec.Cmd, mask os.FileMode) error {
+	if cmd.Err != nil || len(cmd.Args) == 0 {
+		return cmd.Start()
+	}
+	if _, err := os.Stat(umaskShell); err != nil {
+		return fmt.Errorf("umask requires %s: %w", umaskShell, err)
+	}
+	script := fmt.Sprintf("umask %03o && exec \"$0\" \"$@\"", mask.Perm())
+	cmd.Args = append([]string{"sh", "-c", script, cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = umaskShell
+	return cmd.Start()
+}
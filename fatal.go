@@ -0,0 +1,61 @@
+package main
+
+import "errors"
+
+// ErrFatal is the cause of a shutdown escalated by a fatal service failure, see Fatal.
+var ErrFatal = errors.New("fatal service failure")
+
+// Fatal wraps err so that the service failing with it is not restarted, whatever its restart
+// policy, and the supervisor shuts down instead, e.g. for invalid credentials.  Errors may also
+// implement Fatal themselves.  Fatal returns nil if err is nil.
+func Fatal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &fatalError{err}
+}
+
+type fatalError struct {
+	err error
+}
+
+func (e *fatalError) Error() string { return e.err.Error() }
+func (e *fatalError) Unwrap() error { return e.err }
+func (e *fatalError) Fatal() bool   { return true }
+
+// IsFatal reports whether err, or any error it wraps, has a Fatal method returning true.
+func IsFatal(err error) bool {
+	switch e := err.(type) {
+	case nil:
+		return false
+	case interface{ Fatal() bool }:
+		if e.Fatal() {
+			return true
+		}
+	}
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return IsFatal(e.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, err := range e.Unwrap() {
+			if IsFatal(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FailureDecider chooses the response to a failure of the named service, given its consecutive
+// failures including this one, see WithFailureDecider.
+type FailureDecider func(name string, err error, attempt int) Decision
+
+// Decision is the response of the supervisor to a failure, see WithFailureDecider.
+type Decision int
+
+const (
+	DecideDefault  Decision = iota // Apply Fatal, the restart policy and budget as usual.
+	DecideRestart                  // Restart after the usual delay, bypassing policy and budget.
+	DecideStop                     // Leave the service stopped, as if its breaker opened.
+	DecideEscalate                 // Shut down, as if the error were Fatal.
+)
@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned by lockFile when another process holds the lock.
+var errLocked = errors.New("file is locked by another process")
+
+// lockFile is not supported on this platform.
+func lockFile(f *os.File) error {
+	return errors.New("file locking not supported on this platform")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// gateRetry is how long to wait before retrying a Gate that failed to acquire.
+const gateRetry = time.Second * 5
+
+// Gate controls whether a service may run, e.g. only while this process holds leadership.
+// Implementations for etcd, consul, etc. may be plugged in by the application.
+type Gate interface {
+	// Acquire blocks until the gate is held or ctx is done, returning a channel that will be
+	// closed if the gate is later lost.
+	Acquire(ctx context.Context) (lost <-chan struct{}, err error)
+	// Release gives up a held gate.
+	Release() error
+}
+
+// gateChange reports a gated service acquiring or losing its gate.
+type gateChange struct {
+	svc  *Service
+	open bool
+}
+
+// Gate makes the named service run only while g is held.  When g is lost the service is stopped
+// gracefully with ReasonGate, and started again once g is reacquired.  If g implements Validator
+// it is checked with the service.  Gate must not be called once Run has been called.
+func (s *Supervisor) Gate(name string, g Gate) error {
+	svc := s.service(name)
+	if svc == nil {
+		return fmt.Errorf("unknown service %q", name)
+	}
+	if s.gates == nil {
+		s.gates = make(map[*Service]Gate)
+	}
+	s.gates[svc] = g
+	return nil
+}
+
+// watchGate repeatedly acquires g, reporting changes to gatec until ctx is done.
+func (s *Supervisor) watchGate(ctx context.Context, svc *Service, g Gate, gatec chan<- gateChange) {
+	for {
+		lost, err := g.Acquire(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.loggerFor(svc).Error("failed to acquire gate", "service", svc.Name(), "err", err)
+			select {
+			case <-time.After(gateRetry):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		if !sendGate(ctx, gatec, gateChange{svc, true}) {
+			return
+		}
+		select {
+		case <-lost:
+		case <-ctx.Done():
+			return
+		}
+		if !sendGate(ctx, gatec, gateChange{svc, false}) {
+			return
+		}
+	}
+}
+
+// sendGate sends gc to gatec, returning false if ctx is done first.
+func sendGate(ctx context.Context, gatec chan<- gateChange, gc gateChange) bool {
+	select {
+	case gatec <- gc:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// FileGate is a Gate held while this process has an exclusive lock on a file, a simple form of
+// leader election between processes sharing a filesystem.  The gate is lost if the lock file is
+// removed or replaced.
+type FileGate struct {
+	path     string
+	interval time.Duration
+
+	mu   sync.Mutex // Protects fields below.
+	f    *os.File
+	done chan struct{}
+}
+
+// NewFileGate creates a FileGate locking path, attempting to acquire or checking the lock every
+// interval.
+func NewFileGate(path string, interval time.Duration) *FileGate {
+	return &FileGate{path: path, interval: interval}
+}
+
+// Validate implements Validator, checking the lock interval is positive.
+func (g *FileGate) Validate() error {
+	if g.interval <= 0 {
+		return fmt.Errorf("non-positive file gate interval %v", g.interval)
+	}
+	return nil
+}
+
+// Acquire implements Gate.
+func (g *FileGate) Acquire(ctx context.Context) (<-chan struct{}, error) {
+	for {
+		f, err := os.OpenFile(g.path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		err = lockFile(f)
+		if err == nil {
+			lost, done := make(chan struct{}), make(chan struct{})
+			g.mu.Lock()
+			g.f, g.done = f, done
+			g.mu.Unlock()
+			go g.watch(f, lost, done)
+			return lost, nil
+		}
+		f.Close()
+		if err != errLocked {
+			return nil, err
+		}
+		select {
+		case <-time.After(g.interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Release implements Gate.
+func (g *FileGate) Release() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.f == nil {
+		return nil
+	}
+	close(g.done)
+	err := g.f.Close()
+	g.f = nil
+	return err
+}
+
+// watch closes lost if the path no longer refers to the locked file f.
+func (g *FileGate) watch(f *os.File, lost, done chan struct{}) {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			held, err1 := f.Stat()
+			current, err2 := os.Stat(g.path)
+			if err1 != nil || err2 != nil || !os.SameFile(held, current) {
+				// Close our handle so a later Acquire may lock the new file.
+				g.Release()
+				close(lost)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// graphColors are the DOT fill colors of services in each state, see Graph.
+var graphColors = map[State]string{
+	StateIdle:     "white",
+	StateStarting: "lightyellow",
+	StateRunning:  "palegreen",
+	StateStopping: "lightyellow",
+	StateStopped:  "lightgray",
+	StateFailed:   "salmon",
+	StatePaused:   "lightblue",
+	StateDisabled: "lightgray",
+}
+
+// Graph returns the services in DOT format for Graphviz, e.g. `dot -Tsvg`, colored by their
+// current state.  Each phase of the start order is a rank, services started after those they
+// require, solid edges, or want, dashed edges.
+func (s *Supervisor) Graph() string {
+	var b strings.Builder
+	b.WriteString("digraph services {\n\trankdir=LR;\n\tnode [shape=box, style=filled];\n")
+	for i, phase := range s.phases() {
+		fmt.Fprintf(&b, "\tsubgraph phase%d {\n\t\trank=same;\n", i)
+		for _, svc := range phase {
+			snap := svc.Snapshot()
+			fmt.Fprintf(&b, "\t\t%q [label=%q, fillcolor=%s];\n", snap.Name,
+				snap.Name+"\n"+string(snap.State), graphColors[snap.State])
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, svc := range s.list() {
+		deps, _ := s.requirements(svc)
+		for _, dep := range deps {
+			fmt.Fprintf(&b, "\t%q -> %q;\n", dep.name, svc.name)
+		}
+		for _, dep := range s.soft(svc) {
+			fmt.Fprintf(&b, "\t%q -> %q [style=dashed];\n", dep.name, svc.name)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// phases groups the services by the length of their longest chain of dependencies, each phase
+// started once those before it are ready.
+func (s *Supervisor) phases() [][]*Service {
+	var phases [][]*Service
+	for _, svc := range s.list() {
+		depth := s.depth(svc, make(map[*Service]bool))
+		for len(phases) <= depth {
+			phases = append(phases, nil)
+		}
+		phases[depth] = append(phases[depth], svc)
+	}
+	return phases
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ServingStatus is the status of a service in the gRPC health checking protocol,
+// grpc.health.v1.  The values are those of its HealthCheckResponse_ServingStatus, so a status
+// converts directly for a health server, e.g. from google.golang.org/grpc/health.
+type ServingStatus int32
+
+const (
+	ServingUnknown ServingStatus = 0
+	Serving        ServingStatus = 1
+	NotServing     ServingStatus = 2
+	ServiceUnknown ServingStatus = 3
+)
+
+// String returns s as named by grpc.health.v1.
+func (s ServingStatus) String() string {
+	switch s {
+	case Serving:
+		return "SERVING"
+	case NotServing:
+		return "NOT_SERVING"
+	case ServiceUnknown:
+		return "SERVICE_UNKNOWN"
+	}
+	return "UNKNOWN"
+}
+
+// ServingStatuses returns the serving status of each service by name, from its readiness, and of
+// the whole application as the empty name, which is serving unless a critical service is not.
+// Readiness fails once shutdown has begun if WithDrainDelay fails "/readyz", making every status
+// NotServing, so clients stop sending requests.
+func (s *Supervisor) ServingStatuses(ctx context.Context) map[string]ServingStatus {
+	h := s.Readiness(ctx)
+	statuses := make(map[string]ServingStatus, len(h.Services)+1)
+	for _, sh := range h.Services {
+		statuses[sh.Name] = Serving
+		if sh.Status != HealthHealthy {
+			statuses[sh.Name] = NotServing
+		}
+	}
+	statuses[""] = Serving
+	if h.Status == HealthUnhealthy {
+		statuses[""] = NotServing
+	}
+	return statuses
+}
+
+// WatchServingStatus calls set with the name and status of each service, as ServingStatuses,
+// initially and then whenever they change, checking every interval until ctx is done.  set would
+// typically call SetServingStatus of a grpc.health.v1 health server.  An interval that is not
+// positive is rejected.
+func (s *Supervisor) WatchServingStatus(ctx context.Context, interval time.Duration,
+	set func(name string, status ServingStatus)) error {
+	if interval <= 0 {
+		return fmt.Errorf("non-positive serving status interval %v", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := make(map[string]ServingStatus)
+	for {
+		statuses := s.ServingStatuses(ctx)
+		for name, status := range statuses {
+			if old, ok := last[name]; !ok || old != status {
+				set(name, status)
+			}
+		}
+		last = statuses
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
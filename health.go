@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// healthTimeout bounds the health checks run for an admin request.
+const healthTimeout = time.Second * 5
+
+// HealthStatus is the health of a service or the whole application.
+type HealthStatus string
+
+const (
+	HealthHealthy   HealthStatus = "healthy"   // Everything is running and passing its checks.
+	HealthDegraded  HealthStatus = "degraded"  // Only non-critical services are unhealthy.
+	HealthUnhealthy HealthStatus = "unhealthy" // A critical service is unhealthy.
+)
+
+// HealthCheck reports whether a running service is healthy, returning nil if it is.
+type HealthCheck func(ctx context.Context) error
+
+// HealthChecker may be implemented by a Runner to check the health of its service, e.g. by
+// pinging its database.  Health checks count towards readiness, but not liveness.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// LivenessChecker may be implemented by a Runner whose service should be restarted if the check
+// fails, e.g. because it has deadlocked.
+type LivenessChecker interface {
+	CheckLiveness(ctx context.Context) error
+}
+
+// ReadinessChecker may be implemented by a Runner whose service should be taken out of rotation
+// if the check fails, e.g. while it warms a cache.
+type ReadinessChecker interface {
+	CheckReadiness(ctx context.Context) error
+}
+
+// ServiceHealth is the health of a single service.
+type ServiceHealth struct {
+	Name     string       `json:"name"`
+	Status   HealthStatus `json:"status"`
+	State    State        `json:"state"`
+	Critical bool         `json:"critical"`
+	Err      string       `json:"error,omitempty"` // Why the service is unhealthy.
+}
+
+// Health is the overall health of the application, with the detail of each service.
+type Health struct {
+	Status   HealthStatus    `json:"status"`
+	Services []ServiceHealth `json:"services"`
+}
+
+// probe selects the checks and states rolled up by Health, Liveness or Readiness.
+type probe int
+
+const (
+	probeHealth    probe = iota // Running, or paused, and passing all checks.
+	probeLiveness               // Not given up on, and passing liveness checks.
+	probeReadiness              // Running and passing health and readiness checks.
+)
+
+// probeEndpoints are the admin endpoints of each probe, see WithDrainDelay.
+var probeEndpoints = map[string]probe{
+	"/health": probeHealth,
+	"/livez":  probeLiveness,
+	"/readyz": probeReadiness,
+}
+
+// Health runs the health checks of each service, rolling them up with service states into the
+// overall health of the application.  Services must be running and pass all of their checks to
+// be healthy, though services paused by a maintenance window or gate, or disabled, are healthy.
+func (s *Supervisor) Health(ctx context.Context) Health {
+	return s.rollup(ctx, probeHealth)
+}
+
+// Liveness rolls up the liveness checks of each service, unhealthy meaning the application should
+// be restarted.  Services are live until they fail or exit without being restarted.
+func (s *Supervisor) Liveness(ctx context.Context) Health {
+	return s.rollup(ctx, probeLiveness)
+}
+
+// Readiness rolls up the health and readiness checks of each service, unhealthy meaning the
+// application should be taken out of rotation.  Services are only ready while running.
+func (s *Supervisor) Readiness(ctx context.Context) Health {
+	return s.rollup(ctx, probeReadiness)
+}
+
+// rollup checks the services for p, combining their health.
+func (s *Supervisor) rollup(ctx context.Context, p probe) Health {
+	svcs := s.list()
+	h := Health{Status: HealthHealthy, Services: make([]ServiceHealth, len(svcs))}
+	if s.failDraining(p) {
+		h.Status = HealthUnhealthy
+		for i, svc := range svcs {
+			h.Services[i] = ServiceHealth{Name: svc.Name(), Status: HealthUnhealthy,
+				State: svc.Snapshot().State, Critical: !svc.optional, Err: ErrDraining.Error()}
+		}
+		return h
+	}
+	var wg sync.WaitGroup
+	for i, svc := range svcs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.Services[i] = svc.health(ctx, p)
+		}()
+	}
+	wg.Wait()
+	for _, sh := range h.Services {
+		switch {
+		case sh.Status == HealthHealthy:
+		case sh.Critical:
+			h.Status = HealthUnhealthy
+		case h.Status == HealthHealthy:
+			h.Status = HealthDegraded
+		}
+	}
+	return h
+}
+
+// failDraining reports whether p fails because shutdown has begun, see WithDrainDelay.
+func (s *Supervisor) failDraining(p probe) bool {
+	if !s.Draining() {
+		return false
+	}
+	for _, ep := range s.drainProbes {
+		if probeEndpoints[ep] == p {
+			return true
+		}
+	}
+	return false
+}
+
+// probeLiveness restarts running services failing their liveness checks, every interval until ctx
+// is done.
+func (s *Supervisor) probeLiveness(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		for _, svc := range s.list() {
+			if len(svc.livenessChecks()) == 0 {
+				continue
+			}
+			sh := svc.health(ctx, probeLiveness)
+			if sh.Status == HealthHealthy || sh.State != StateRunning || ctx.Err() != nil {
+				continue
+			}
+			s.loggerFor(svc).Warn("liveness check failed, restarting", "service", svc.Name(),
+				"err", sh.Err)
+			if err := s.Restart(svc.Name(), ReasonLiveness); err != nil {
+				s.loggerFor(svc).Error("failed to restart service", "service", svc.Name(), "err", err)
+			}
+		}
+	}
+}
+
+// health checks this service for p.
+func (s *Service) health(ctx context.Context, p probe) ServiceHealth {
+	snap := s.Snapshot()
+	sh := ServiceHealth{Name: s.name, Status: HealthHealthy, State: snap.State, Critical: !s.optional}
+	var err error
+	switch {
+	case snap.State == StateRunning:
+		err = s.check(ctx, p)
+	case snap.State == StateDisabled:
+	case p == probeHealth && snap.State == StatePaused:
+	case p == probeLiveness && snap.State != StateFailed && snap.State != StateStopped:
+	default:
+		err = errors.New("not running")
+		if snap.Err != nil {
+			err = snap.Err
+		}
+	}
+	if err != nil {
+		sh.Status, sh.Err = HealthUnhealthy, err.Error()
+	}
+	return sh
+}
+
+// check runs the checks of this service for p.
+func (s *Service) check(ctx context.Context, p probe) error {
+	var checks []HealthCheck
+	if p != probeLiveness {
+		checks = append(checks, s.healthChecks()...)
+		checks = append(checks, s.readiness...)
+		if rc, ok := s.instance().(ReadinessChecker); ok {
+			checks = append(checks, rc.CheckReadiness)
+		}
+	}
+	if p != probeReadiness {
+		checks = append(checks, s.livenessChecks()...)
+	}
+	for _, check := range checks {
+		if err := check(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// healthChecks returns the health checks of this service, which count towards readiness only.
+func (s *Service) healthChecks() []HealthCheck {
+	checks := s.checks
+	if hc, ok := s.instance().(HealthChecker); ok {
+		checks = append([]HealthCheck{hc.CheckHealth}, checks...)
+	}
+	return checks
+}
+
+// livenessChecks returns the liveness only checks of this service.
+func (s *Service) livenessChecks() []HealthCheck {
+	checks := s.liveness
+	if lc, ok := s.instance().(LivenessChecker); ok {
+		checks = append([]HealthCheck{lc.CheckLiveness}, checks...)
+	}
+	return checks
+}
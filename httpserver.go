@@ -0,0 +1,206 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDraining is the readiness error of a server that has begun draining, see HTTPServer.
+var ErrDraining = errors.New("draining")
+
+// HTTPServer is a Runner serving an http.Server, which drains gracefully when the service is
+// stopped: readiness fails, keep-alives are disabled, new requests are rejected by
+// RejectDraining, and in-flight requests are given the grace period to finish before connections
+// are closed.  Handlers of long-lived streams register them with Stream, to be closed gracefully.
+type HTTPServer struct {
+	newServer func() *http.Server
+	grace     time.Duration
+
+	srv      *http.Server // Of the current run, set by Start.
+	ln       net.Listener
+	draining atomic.Bool
+
+	mu      sync.Mutex // Protects streams, and the start of draining.
+	streams map[*Stream]struct{}
+	active  sync.WaitGroup // Streams whose handlers have not returned.
+}
+
+// Stream is a long-lived streaming request registered with HTTPServer.Stream.
+type Stream struct {
+	h       *HTTPServer
+	ctx     context.Context
+	cancel  context.CancelFunc
+	grace   time.Duration
+	onDrain func()
+	once    sync.Once
+}
+
+// NewHTTPServer creates an HTTPServer serving the server returned by newServer, which is called
+// for each run as an http.Server cannot serve again once shut down.  The server serves TLS if its
+// TLSConfig is set.  Draining in-flight requests is limited to grace, if not zero, as well as by
+// the service's stop timeout.
+func NewHTTPServer(newServer func() *http.Server, grace time.Duration) *HTTPServer {
+	return &HTTPServer{newServer: newServer, grace: grace}
+}
+
+// Start implements Starter, listening on the address of the server, so the service only becomes
+// ready once it is accepting connections.
+func (h *HTTPServer) Start(ctx context.Context) error {
+	srv := h.newServer()
+	var lc net.ListenConfig
+	ln, err := lc.Listen(ctx, "tcp", cmp.Or(srv.Addr, ":http"))
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		ln.Close()
+		return context.Cause(ctx)
+	}
+	handler := srv.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	srv.Handler = RejectDraining(h.Draining, handler)
+	h.srv, h.ln = srv, ln
+	h.draining.Store(false)
+	return nil
+}
+
+// Run implements Runner, serving until ctx is done, and then draining.
+func (h *HTTPServer) Run(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		if h.srv.TLSConfig != nil {
+			// Certificates come from the TLSConfig, e.g. of a CertReloader.
+			errc <- h.srv.ServeTLS(h.ln, "", "")
+			return
+		}
+		errc <- h.srv.Serve(h.ln)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	h.mu.Lock()
+	h.draining.Store(true)
+	for st := range h.streams {
+		st.drain()
+	}
+	h.mu.Unlock()
+	// Close connections once their in-flight request is done, rather than pinning the grace
+	// period on keep-alive connections.
+	h.srv.SetKeepAlivesEnabled(false)
+	sctx, cancel := context.WithoutCancel(ctx), context.CancelFunc(func() {})
+	if h.grace > 0 {
+		sctx, cancel = context.WithTimeout(sctx, h.grace)
+	}
+	defer cancel()
+	err := h.srv.Shutdown(sctx)
+	if err == nil {
+		// Hijacked connections, e.g. WebSockets, are not waited for by Shutdown.
+		err = waitGroup(sctx, &h.active)
+	}
+	if err != nil {
+		h.srv.Close()
+		err = fmt.Errorf("drain: %w", err)
+	}
+	<-errc
+	return err
+}
+
+// Draining reports whether the server has begun draining.
+func (h *HTTPServer) Draining() bool {
+	return h.draining.Load()
+}
+
+// CheckReadiness implements ReadinessChecker, failing with ErrDraining once draining has begun,
+// so load balancers stop routing requests to the server.
+func (h *HTTPServer) CheckReadiness(context.Context) error {
+	if h.Draining() {
+		return ErrDraining
+	}
+	return nil
+}
+
+// RejectDraining returns middleware responding 503 Service Unavailable, and closing the
+// connection, to requests arriving once draining reports true, rather than passing them to next.
+// HTTPServer applies it to the handler of its server.
+func RejectDraining(draining func() bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if draining() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stream registers a long-lived streaming request r, e.g. a WebSocket or server-sent events, with
+// the server.  When draining begins onDrain is called, in a new goroutine, for the handler to
+// send a close frame or final event and return.  If it has not returned within grace the context
+// of the Stream is canceled, and the handler must then close a hijacked connection at once.  The
+// handler must call Done on the Stream when it returns.
+func (h *HTTPServer) Stream(r *http.Request, grace time.Duration, onDrain func()) *Stream {
+	ctx, cancel := context.WithCancel(r.Context())
+	st := &Stream{h: h, ctx: ctx, cancel: cancel, grace: grace, onDrain: onDrain}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.streams == nil {
+		h.streams = make(map[*Stream]struct{})
+	}
+	h.streams[st] = struct{}{}
+	h.active.Add(1)
+	if h.draining.Load() {
+		st.drain()
+	}
+	return st
+}
+
+// Context returns the context to serve the stream with, canceled if its handler has not
+// returned within the grace period after draining began.
+func (st *Stream) Context() context.Context {
+	return st.ctx
+}
+
+// Done unregisters the stream once its handler returns.
+func (st *Stream) Done() {
+	st.once.Do(func() {
+		st.cancel()
+		st.h.mu.Lock()
+		delete(st.h.streams, st)
+		st.h.mu.Unlock()
+		st.h.active.Done()
+	})
+}
+
+// drain asks the handler of the stream to close it, canceling its context after its grace.
+func (st *Stream) drain() {
+	if st.onDrain != nil {
+		go st.onDrain()
+	}
+	time.AfterFunc(st.grace, st.cancel)
+}
+
+// waitGroup waits for wg, or until ctx is done.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
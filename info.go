@@ -0,0 +1,64 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Info describes the running binary, supervisor configuration and registered services, for
+// startup logs and fleet inventory.
+type Info struct {
+	Path          string        `json:"path"`
+	Version       string        `json:"version"`
+	Revision      string        `json:"revision,omitempty"`
+	GoVersion     string        `json:"go_version"`
+	RestartBudget int           `json:"restart_budget"` // Remaining, -1 if unlimited.
+	RunFor        time.Duration `json:"run_for,omitempty"`
+	RunUntil      time.Time     `json:"run_until,omitzero"`
+	Services      []string      `json:"services"`
+	Dropped       int           `json:"dropped_events,omitempty"` // See Supervisor.Dropped.
+}
+
+// Info returns build and configuration info for the supervisor.
+func (s *Supervisor) Info() Info {
+	info := Info{
+		Version:       "unknown",
+		GoVersion:     runtime.Version(),
+		RestartBudget: -1,
+		RunFor:        s.duration,
+		RunUntil:      s.deadline,
+		Dropped:       s.Dropped(),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info.Path, info.Version = bi.Main.Path, bi.Main.Version
+		for _, setting := range bi.Settings {
+			if setting.Key == "vcs.revision" {
+				info.Revision = setting.Value
+			}
+		}
+	}
+	if s.budgeted {
+		s.mu.Lock()
+		info.RestartBudget = s.restarts
+		s.mu.Unlock()
+	}
+	for _, svc := range s.list() {
+		info.Services = append(info.Services, svc.Name())
+	}
+	return info
+}
+
+// logBanner logs Info as a single structured startup line.
+func (s *Supervisor) logBanner() {
+	info := s.Info()
+	s.logger.Info("starting supervisor",
+		"path", info.Path,
+		"version", info.Version,
+		"revision", info.Revision,
+		"go", info.GoVersion,
+		"restart_budget", info.RestartBudget,
+		"run_for", info.RunFor,
+		"run_until", info.RunUntil,
+		"services", info.Services)
+}
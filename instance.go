@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ErrAlreadyRunning is returned by Run when another process holds the single-instance lock.
+var ErrAlreadyRunning = errors.New("already running")
+
+// lockInstance takes an exclusive lock on path, recording the pid of this process in it.  The
+// lock is held until the returned file is closed.
+func lockInstance(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		if err == errLocked {
+			if pid, _ := readPIDFile(path); pid > 0 {
+				return nil, fmt.Errorf("%w as pid %d (lock file %s)", ErrAlreadyRunning, pid, path)
+			}
+			return nil, fmt.Errorf("%w (lock file %s)", ErrAlreadyRunning, path)
+		}
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
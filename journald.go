@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// journaldSocket is where journald receives entries in its native protocol.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// JournaldHandler is a slog.Handler writing entries directly to journald, for systemd deployments
+// where the multiplexed stdout of a process loses which service logged a line.  Levels map to
+// syslog priorities, the service attribute to the SERVICE field, and other attributes to fields
+// named by their upper-cased keys.
+type JournaldHandler struct {
+	conn  *net.UnixConn
+	ident string
+	level slog.Leveler
+	attrs []slog.Attr
+	group string // Prefix for attribute keys, from WithGroup.
+}
+
+// NewJournaldHandler creates a JournaldHandler logging at opts.Level or above if opts is not nil,
+// identified by the name of this program.  It fails if journald is not running.
+func NewJournaldHandler(opts *slog.HandlerOptions) (*JournaldHandler, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("journald: %w", err)
+	}
+	h := &JournaldHandler{conn: conn, ident: filepath.Base(os.Args[0]), level: slog.LevelInfo}
+	if opts != nil && opts.Level != nil {
+		h.level = opts.Level
+	}
+	return h, nil
+}
+
+// Enabled implements slog.Handler.
+func (h *JournaldHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// WithAttrs implements slog.Handler.
+func (h *JournaldHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], h.prefixed(attrs)...)
+	return &c
+}
+
+// WithGroup implements slog.Handler.
+func (h *JournaldHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.group = h.group + name + "_"
+	return &c
+}
+
+// Handle implements slog.Handler.
+func (h *JournaldHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	journaldField(&b, "MESSAGE", r.Message)
+	journaldField(&b, "PRIORITY", fmt.Sprint(journaldPriority(r.Level)))
+	journaldField(&b, "SYSLOG_IDENTIFIER", h.ident)
+	attrs := h.attrs
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs[:len(attrs):len(attrs)], h.prefixed([]slog.Attr{a})...)
+		return true
+	})
+	for _, a := range attrs {
+		if name := journaldName(a.Key); name != "" {
+			journaldField(&b, name, a.Value.Resolve().String())
+		}
+	}
+	// Entries too large for a datagram fail, journald would need them passed in a memfd.
+	_, err := h.conn.Write(b.Bytes())
+	return err
+}
+
+// Close closes the connection to journald.
+func (h *JournaldHandler) Close() error {
+	return h.conn.Close()
+}
+
+// prefixed returns attrs with keys prefixed by our group.
+func (h *JournaldHandler) prefixed(attrs []slog.Attr) []slog.Attr {
+	if h.group == "" {
+		return attrs
+	}
+	out := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		out[i] = slog.Attr{Key: h.group + a.Key, Value: a.Value}
+	}
+	return out
+}
+
+// journaldPriority returns the syslog priority of level.
+func journaldPriority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return 3 // err
+	case level >= slog.LevelWarn:
+		return 4 // warning
+	case level >= slog.LevelInfo:
+		return 6 // info
+	}
+	return 7 // debug
+}
+
+// journaldName returns key as a journald field name, upper-cased with other characters than
+// letters and digits replaced, or an empty string if it has none.  Fields may not begin with an
+// underscore or digit.
+func journaldName(key string) string {
+	name := strings.TrimLeft(strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key), "_0123456789")
+	switch name {
+	case "MESSAGE", "PRIORITY", "SYSLOG_IDENTIFIER":
+		// Reserved for the record itself.
+		return "ATTR_" + name
+	}
+	return name
+}
+
+// journaldField appends a field to the entry in b, in the binary form if value spans lines.
+func journaldField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// EnsureRunning starts a service created WithLazy, or stopped by WithIdleTimeout, if it is not
+// already running, waiting until it is ready or ctx is done.  Consuming code should call it
+// before each use, which also counts as activity.
+func (s *Service) EnsureRunning(ctx context.Context) error {
+	s.mu.Lock()
+	running := s.state == StateRunning
+	runs, wanted := s.runs, s.wanted
+	if s.state == StateStarting {
+		// Wait for the current run.
+		runs--
+	}
+	s.wanted, s.active = true, time.Now()
+	sup := s.sup
+	s.mu.Unlock()
+	if running {
+		return nil
+	}
+	if sup == nil {
+		return errors.New("service " + s.name + " has not been added to a supervisor")
+	}
+	if !wanted {
+		sup.Reconcile()
+	}
+	return s.waitRestarted(ctx, runs)
+}
+
+// Touch records activity of the service, deferring its idle timeout.
+func (s *Service) Touch() {
+	s.mu.Lock()
+	s.active = time.Now()
+	s.mu.Unlock()
+}
+
+// ActivityReporter may be implemented by the Runner of a service created WithIdleTimeout,
+// reporting when it was last active, in addition to calls to Touch.
+type ActivityReporter interface {
+	Activity() time.Time
+}
+
+// dormant reports whether the service is lazy, or stopped for being idle, and not yet wanted.
+func (s *Service) dormant() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return (s.lazy || s.idled) && !s.wanted
+}
+
+// inactive reports whether the running service has been inactive for its idle timeout at now,
+// marking it no longer wanted if so.
+func (s *Service) inactive(now time.Time) bool {
+	if s.idleTimeout <= 0 {
+		return false
+	}
+	var reported time.Time
+	if ar, ok := s.instance().(ActivityReporter); ok {
+		reported = ar.Activity()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state != StateRunning {
+		return false
+	}
+	last := s.upSince
+	for _, t := range []time.Time{s.active, reported} {
+		if t.After(last) {
+			last = t
+		}
+	}
+	if now.Sub(last) < s.idleTimeout {
+		return false
+	}
+	s.wanted, s.idled = false, true
+	return true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// SupervisorComponent names the supervisor core, as opposed to its services, for SetLogLevel.
+const SupervisorComponent = "supervisor"
+
+// levelHandler filters records by a level that can be changed at runtime, deferring to the
+// wrapped handler until it is set.
+type levelHandler struct {
+	slog.Handler
+	level *componentLevel
+}
+
+// componentLevel is the runtime log level of a component.
+type componentLevel struct {
+	mu    sync.Mutex
+	set   bool
+	level slog.Level
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	h.level.mu.Lock()
+	set, min := h.level.set, h.level.level
+	h.level.mu.Unlock()
+	if set {
+		return level >= min
+	}
+	return h.Handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// leveled returns l filtered by the runtime log level of component.
+func (s *Supervisor) leveled(component string, l *slog.Logger) *slog.Logger {
+	s.levelMu.Lock()
+	defer s.levelMu.Unlock()
+	return slog.New(&levelHandler{l.Handler(), s.componentLevel(component)})
+}
+
+// componentLevel returns the level of component, must be called with levelMu held.
+func (s *Supervisor) componentLevel(component string) *componentLevel {
+	if s.levels == nil {
+		s.levels = make(map[string]*componentLevel)
+	}
+	level := s.levels[component]
+	if level == nil {
+		level = &componentLevel{}
+		s.levels[component] = level
+	}
+	return level
+}
+
+// SetLogLevel sets the log level of a service, or of SupervisorComponent, at runtime.  This
+// overrides the level of its logger, e.g. to enable debug logging for one misbehaving service.
+func (s *Supervisor) SetLogLevel(component string, level slog.Level) error {
+	if component != SupervisorComponent && s.service(component) == nil {
+		return fmt.Errorf("unknown component %q", component)
+	}
+	s.levelMu.Lock()
+	l := s.componentLevel(component)
+	s.levelMu.Unlock()
+	l.mu.Lock()
+	l.set, l.level = true, level
+	l.mu.Unlock()
+	return nil
+}
+
+// LogLevels returns the log levels set with SetLogLevel, by component.
+func (s *Supervisor) LogLevels() map[string]slog.Level {
+	s.levelMu.Lock()
+	defer s.levelMu.Unlock()
+	levels := make(map[string]slog.Level)
+	for name, l := range s.levels {
+		l.mu.Lock()
+		if l.set {
+			levels[name] = l.level
+		}
+		l.mu.Unlock()
+	}
+	return levels
+}
+
+// ToggleDebug switches the log level of the supervisor and all services between debug and info,
+// announcing the new level with EventLogLevel, and returns it.
+func (s *Supervisor) ToggleDebug() slog.Level {
+	level := slog.LevelDebug
+	if current, ok := s.LogLevels()[SupervisorComponent]; ok && current <= slog.LevelDebug {
+		level = slog.LevelInfo
+	}
+	s.SetLogLevel(SupervisorComponent, level)
+	for _, svc := range s.list() {
+		s.SetLogLevel(svc.Name(), level)
+	}
+	s.logger.Info("log level changed", "level", level)
+	s.emit(Event{Type: EventLogLevel, Level: level})
+	return level
+}
+
+// ToggleDebugOnSignal calls ToggleDebug each time one of sigs, e.g. SIGUSR2, is received, until
+// ctx is done, so verbose diagnostics can be enabled in production without a restart.
+func (s *Supervisor) ToggleDebugOnSignal(ctx context.Context, sigs ...os.Signal) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, sigs...)
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case <-sigc:
+			s.ToggleDebug()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import "context"
+
+// Lock is a distributed mutual exclusion lock, e.g. backed by etcd, consul or a database, shared
+// by all instances of a horizontally scaled deployment.
+type Lock interface {
+	// Acquire blocks until the lock is held or ctx is done.
+	Acquire(ctx context.Context) error
+	// Release gives up a held lock.
+	Release() error
+	// Lost returns a channel closed when a held lock is lost, e.g. a lease expired.
+	Lost() <-chan struct{}
+}
+
+// Singleton makes the named service run on at most one instance sharing l: only while l is held.
+// If l is lost the service is stopped, and another instance acquiring l will start its copy.
+// Singleton must not be called once Run has been called.
+func (s *Supervisor) Singleton(name string, l Lock) error {
+	return s.Gate(name, lockGate{l})
+}
+
+// lockGate adapts a Lock to the Gate interface.
+type lockGate struct {
+	Lock
+}
+
+// Acquire implements Gate.
+func (g lockGate) Acquire(ctx context.Context) (<-chan struct{}, error) {
+	if err := g.Lock.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	return g.Lost(), nil
+}
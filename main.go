@@ -3,122 +3,202 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"math"
+	"net/http"
 	"os"
-	"os/signal"
 	"syscall"
 	"time"
 )
 
-var clean = flag.Bool("clean", false, "services won't fail, requiring signal to exit.")
+var (
+	clean   = flag.Bool("clean", false, "services won't fail, requiring signal to exit.")
+	runFor  = flag.Duration("run-for", 0, "shutdown gracefully after running for this long.")
+	admin   = flag.String("admin", "", "serve the admin API on this address, e.g. localhost:8080.")
+	cert    = flag.String("admin-cert", "", "serve the admin API by TLS with this certificate file.")
+	key     = flag.String("admin-key", "", "private key file of -admin-cert, reloaded on SIGHUP.")
+	color   = flag.Bool("color", false, "log in color for reading in a terminal.")
+	logFile = flag.String("log-file", "", "log to this file, rotated at 10MB and reopened on SIGHUP.")
+	journal = flag.Bool("journald", false, "log directly to journald, for running under systemd.")
+	webhook = flag.String("webhook", "", "POST failures and shutdown to this URL.")
+	syslogs = flag.String("syslog", "", "send lifecycle events to syslog: local, or host:port by UDP.")
+	dev     = flag.Bool("dev", false, "restart services when files in the current directory change.")
+	chaos   = flag.Duration("chaos", 0, "randomly kill a service about this often, and delay it.")
+	plan    = flag.Bool("plan", false, "print the start and shutdown plan, without starting anything.")
+)
 
-// Service represents a long running service in our application.
-type Service struct {
+// demo is the Runner for our example services.
+type demo struct {
 	name    string
 	timeout time.Duration
-	ctx     context.Context
-	cancel  context.CancelFunc
-}
-
-// New creates a new Service that will fail after timeout.
-func New(name string, timeout time.Duration) *Service {
-	return &Service{name: name, timeout: timeout}
-}
-
-// Start calls run() in a new goroutine, returning an error channel which will be closed once
-// this service has exited.  Start is not thread safe, do not call from multiple goroutines.
-func (s *Service) Start() <-chan error {
-	s.ctx, s.cancel = context.WithCancel(context.Background())
-	errc := make(chan error)
-	go func() {
-		defer close(errc)
-		if err := s.run(); err != nil {
-			errc <- err
-		}
-	}()
-	return errc
 }
 
-// Stop requests our service to shutdown.
-func (s *Service) Stop() {
-	s.cancel()
+// Validate checks the configuration of the demo, before anything is started.
+func (d *demo) Validate() error {
+	if d.timeout <= 0 {
+		return fmt.Errorf("demo %s: timeout must be positive, got %v", d.name, d.timeout)
+	}
+	return nil
 }
 
-// run would be where our service performs its work, starts its listener, etc.
-func (s *Service) run() error {
-	log.Printf("service %s started", s.name)
-	// s.ctx should be used as a parent for request contexts, and sync.WaitGroup leveraged to
-	// prevent this function from returning until all workers are finished.
-	failc := time.After(time.Hour * 1000)
+// Run would be where our service performs its work, starts its listener, etc.
+func (d *demo) Run(ctx context.Context) error {
+	// Request contexts should be created with FromContext(ctx).NewRequestContext, or other work
+	// registered with Track, so the service does not exit until it is all finished.
+	var failc <-chan time.Time
 	if !*clean {
-		failc = time.After(s.timeout)
+		failc = time.After(d.timeout)
 	}
 	select {
 	case <-failc:
 		// Pretend there was an error requiring this service to stop.
-		return fmt.Errorf("service %s timed out after %v", s.name, s.timeout)
-	case <-s.ctx.Done():
+		return fmt.Errorf("timed out after %v", d.timeout)
+	case <-ctx.Done():
 		// Stop requested.
-		log.Printf("service %s stopped", s.name)
 	}
 	return nil
 }
 
 // main starts our services, restarts them after failures.
 func main() {
-	flag.Parse()
+	Main(build)
+}
 
-	// Create services, ignoring configuration errors.
-	a := New("a", time.Second*3)
-	b := New("b", time.Second*2)
-	c := New("c", time.Second*5)
-	// Start services.
-	ac := a.Start()
-	bc := b.Start()
-	cc := c.Start()
-	// Setup signal handler
-	sigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT)
-retryLoop:
-	for retries := 2; retries >= 0; retries-- {
-		// Wait for any service to fail, restart them a couple times.
-		select {
-		case err := <-ac:
-			log.Printf("error: %v", err)
-			if retries > 0 {
-				ac = a.Start()
-			}
-		case err := <-bc:
-			log.Printf("error: %v", err)
-			if retries > 0 {
-				bc = b.Start()
+// build configures the supervisor from our flags, and registers our services.
+func build(sup *Supervisor) error {
+	if *color {
+		slog.SetDefault(slog.New(NewConsoleHandler(os.Stderr, nil)))
+		sup.Apply(WithSupervisorLogger(slog.Default()))
+	}
+	if *logFile != "" {
+		rf := NewRotatingFile(*logFile, RotateOptions{
+			MaxSize: 10 << 20,
+			Backups: 3,
+			Signals: []os.Signal{syscall.SIGHUP},
+		})
+		slog.SetDefault(slog.New(slog.NewTextHandler(rf, nil)))
+		sup.Apply(WithSupervisorLogger(slog.Default()))
+		// Stopped last, to log the shutdown of the other services.
+		sup.Add(New("log", WithRunner(rf), WithShutdownRank(math.MaxInt)))
+	}
+	if *journal {
+		h, err := NewJournaldHandler(nil)
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(slog.New(h))
+		sup.Apply(WithSupervisorLogger(slog.Default()))
+	}
+	sup.Apply(WithRestartBudget(2), WithStartupBanner())
+	if *runFor > 0 {
+		sup.Apply(WithRunFor(*runFor))
+	}
+	if *webhook != "" {
+		wh := NewWebhook(http.DefaultClient, []string{*webhook}, nil)
+		sup.Apply(WithEventHook(wh.Notify), WithExitHook(func(error) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			if err := wh.Close(ctx); err != nil {
+				log.Printf("webhook not delivered: %v", err)
 			}
-		case err := <-cc:
-			log.Printf("error: %v", err)
-			if retries > 0 {
-				cc = c.Start()
+		}))
+	}
+	if *syslogs != "" {
+		network, addr := "udp", *syslogs
+		if addr == "local" {
+			network, addr = "", ""
+		}
+		sl := NewSyslog(network, addr, nil)
+		sup.Apply(WithEventHook(sl.Notify), WithExitHook(func(error) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+			defer cancel()
+			if err := sl.Close(ctx); err != nil {
+				log.Printf("syslog not delivered: %v", err)
 			}
-		case sig := <-sigc:
-			log.Printf("got signal %v", sig)
-			break retryLoop
+		}))
+	}
+	if *chaos > 0 {
+		sup.Apply(WithChaos(NewChaos(nil, ChaosOptions{
+			KillEvery:  *chaos,
+			StartDelay: time.Second,
+			StopDelay:  time.Second,
+			Rate:       0.5,
+		})))
+	}
+	sup.Use(LogLifecycle(), Metrics(NewExpvarRecorder("services")))
+	sup.PublishResources("resources")
+	sup.Add(
+		New("a", WithRunner(&demo{name: "a", timeout: time.Second * 3})),
+		New("b", WithRunner(&demo{name: "b", timeout: time.Second * 2})),
+		New("c", WithRunner(&demo{name: "c", timeout: time.Second * 5})),
+	)
+	if *dev {
+		sup.Add(New("dev", WithRunner(NewDevWatcher(sup, nil, time.Second, time.Second, "."))))
+	}
+	var adminTLS *tls.Config
+	if *admin != "" && *cert != "" {
+		files := TLSFiles{Cert: *cert, Key: *key}
+		certs, err := NewCertReloader(files)
+		if err != nil {
+			return err
 		}
-		log.Printf("(%v retries remaining)", retries)
+		adminTLS = certs.TLSConfig()
+		// Reload the certificate in place on SIGHUP, e.g. once renewed.
+		cfg := NewConfig(sup, files, "tls")
+		src := ConfigSourceFunc[TLSFiles](func(context.Context) (TLSFiles, error) {
+			return files, nil
+		})
+		sup.Add(
+			New("tls", WithRunner(certs)),
+			New("tls-reload", WithRunner(RunFunc(func(ctx context.Context) error {
+				cfg.ReloadOnSignal(ctx, src, syscall.SIGHUP)
+				return nil
+			}))),
+		)
 	}
-	log.Printf("shutting down")
-	// Stop all services.
-	a.Stop()
-	b.Stop()
-	c.Stop()
-	// Wait for all services to finish.
-	if err := <-ac; err != nil {
-		log.Printf("a error: %v", err)
+	// Configuration errors are reported together by Validate.
+	if err := sup.Validate(); err != nil {
+		return err
 	}
-	if err := <-bc; err != nil {
-		log.Printf("b error: %v", err)
+	if *plan {
+		p, err := sup.Plan()
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(p); err != nil {
+			return fmt.Errorf("write plan: %w", err)
+		}
+		return ErrNoRun
 	}
-	if err := <-cc; err != nil {
-		log.Printf("c error: %v", err)
+	if *admin != "" {
+		srv := &http.Server{Addr: *admin, Handler: sup.AdminHandler(), TLSConfig: adminTLS}
+		go func() {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ListenAndServeTLS("", "")
+			} else {
+				err = srv.ListenAndServe()
+			}
+			log.Printf("admin API error: %v", err)
+		}()
 	}
+	// Log lifecycle events, and the final state of each service.
+	go func() {
+		for ev := range sup.Events() {
+			log.Printf("event: %v", ev)
+		}
+	}()
+	sup.Apply(WithExitHook(func(error) {
+		for _, snap := range sup.Snapshot() {
+			log.Printf("service %s %s (%s)", snap.Name, snap.State, snap.Reason)
+		}
+	}))
+	return nil
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// Recorder receives service metrics from the Metrics middleware, adapting them to a metrics
+// backend such as Prometheus.
+type Recorder interface {
+	RunDuration(service string, d time.Duration, err error) // Called as each run ends.
+	Failure(service string)                                 // Called as a run fails.
+	RestartLatency(service string, d time.Duration)         // From a run ending to the next starting.
+}
+
+// Metrics returns Middleware recording the runs of each service with rec.
+func Metrics(rec Recorder) Middleware {
+	var mu sync.Mutex
+	ended := make(map[*Service]time.Time) // When each service last ended a run.
+	return func(r Runner) Runner {
+		return RunFunc(func(ctx context.Context) error {
+			svc := FromContext(ctx)
+			name := ""
+			if svc != nil {
+				name = svc.Name()
+			}
+			start := time.Now()
+			mu.Lock()
+			last, ok := ended[svc]
+			mu.Unlock()
+			if ok {
+				rec.RestartLatency(name, start.Sub(last))
+			}
+			err := r.Run(ctx)
+			end := time.Now()
+			mu.Lock()
+			ended[svc] = end
+			mu.Unlock()
+			rec.RunDuration(name, end.Sub(start), err)
+			if err != nil && ctx.Err() == nil {
+				rec.Failure(name)
+			}
+			return err
+		})
+	}
+}
+
+// ExpvarRecorder is a Recorder publishing metrics with the expvar package, served at
+// /debug/vars when using http.DefaultServeMux.
+type ExpvarRecorder struct {
+	runs     *expvar.Map // Runs by service.
+	failures *expvar.Map // Failures by service.
+	runTime  *expvar.Map // Cumulative run time by service, in seconds.
+	latency  *expvar.Map // Most recent restart latency by service, in seconds.
+}
+
+// NewExpvarRecorder creates an ExpvarRecorder publishing maps named with prefix, e.g.
+// prefix_runs.  It panics if the names are already published.
+func NewExpvarRecorder(prefix string) *ExpvarRecorder {
+	return &ExpvarRecorder{
+		runs:     expvar.NewMap(prefix + "_runs"),
+		failures: expvar.NewMap(prefix + "_failures"),
+		runTime:  expvar.NewMap(prefix + "_run_seconds"),
+		latency:  expvar.NewMap(prefix + "_restart_latency_seconds"),
+	}
+}
+
+// RunDuration implements Recorder.
+func (e *ExpvarRecorder) RunDuration(service string, d time.Duration, err error) {
+	e.runs.Add(service, 1)
+	e.runTime.AddFloat(service, d.Seconds())
+}
+
+// Failure implements Recorder.
+func (e *ExpvarRecorder) Failure(service string) {
+	e.failures.Add(service, 1)
+}
+
+// RestartLatency implements Recorder.
+func (e *ExpvarRecorder) RestartLatency(service string, d time.Duration) {
+	v := new(expvar.Float)
+	v.Set(d.Seconds())
+	e.latency.Set(service, v)
+}
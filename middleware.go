@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// Middleware wraps a Runner, e.g. to add logging, metrics or timeouts to its Run.  Optional
+// interfaces implemented by the wrapped Runner, such as Starter, are still used by the service.
+type Middleware func(Runner) Runner
+
+// Use adds middleware wrapping the Runner of every service, the first being outermost.  Use must
+// not be called once Run has been called.
+func (s *Supervisor) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// chain returns r wrapped by mw, the first being outermost.
+func chain(r Runner, mw []Middleware) Runner {
+	for i := len(mw) - 1; i >= 0; i-- {
+		r = mw[i](r)
+	}
+	return r
+}
+
+// RunTimeoutError is the failure of a service which ran for longer than the limit set by
+// RunTimeout.
+type RunTimeoutError struct {
+	Limit time.Duration
+}
+
+func (e *RunTimeoutError) Error() string {
+	return fmt.Sprintf("run exceeded time limit of %v", e.Limit)
+}
+
+// RunTimeout returns Middleware limiting each run of a service to limit, for batch or one-shot
+// services.  The context passed to Run is canceled once the limit is reached, and the service
+// fails with a RunTimeoutError.
+func RunTimeout(limit time.Duration) Middleware {
+	return func(r Runner) Runner {
+		return RunFunc(func(ctx context.Context) error {
+			tctx, cancel := context.WithTimeoutCause(ctx, limit, &RunTimeoutError{Limit: limit})
+			defer cancel()
+			err := r.Run(tctx)
+			if ctx.Err() == nil && tctx.Err() != nil {
+				// Overran, the error returned was likely caused by the cancellation.
+				return context.Cause(tctx)
+			}
+			return err
+		})
+	}
+}
+
+// LogLifecycle returns Middleware logging the start and end of each run of a service to its
+// logger, with its duration and a classification of how it ended: ok, canceled, timeout, panic
+// or error.  The logger from LoggerFromContext adds the generation of the run.
+func LogLifecycle() Middleware {
+	return func(r Runner) Runner {
+		return RunFunc(func(ctx context.Context) (err error) {
+			logger := LoggerFromContext(ctx)
+			name := ""
+			if svc := FromContext(ctx); svc != nil {
+				name = svc.Name()
+			}
+			logger.Info("service running", "service", name)
+			start := time.Now()
+			defer func() {
+				v := recover()
+				class := "ok"
+				switch {
+				case v != nil:
+					class = "panic"
+				case errors.As(err, new(*RunTimeoutError)):
+					class = "timeout"
+				case err != nil && ctx.Err() != nil:
+					class = "canceled"
+				case err != nil:
+					class = "error"
+				}
+				args := []any{"service", name, "duration", time.Since(start), "result", class}
+				if err != nil {
+					args = append(args, "err", err)
+				}
+				level := slog.LevelInfo
+				if class != "ok" && class != "canceled" {
+					level = slog.LevelError
+				}
+				logger.Log(context.Background(), level, "service run ended", args...)
+				if v != nil {
+					panic(v)
+				}
+			}()
+			return r.Run(ctx)
+		})
+	}
+}
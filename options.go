@@ -0,0 +1,610 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Option configures a Service, see New.
+type Option func(*Service)
+
+// RestartPolicy is a circuit breaker limiting how often the supervisor restarts a failing
+// service.  Once the service has failed more than MaxRestarts times within Window, the breaker
+// opens and the service is left failed.  Panics usually indicate bugs rather than transient
+// conditions, so may be limited further.
+type RestartPolicy struct {
+	MaxRestarts int           // Restarts allowed within Window.
+	Window      time.Duration // Period failures are counted over, zero for the supervisor's lifetime.
+	Delay       time.Duration // Wait before each restart, unless WithBackoff is used.
+	MaxPanics   int           // Restarts allowed after panics within Window, zero for no extra limit.
+
+	NoRestartOnPanic bool // Open the breaker on the first panic.
+}
+
+// validate returns the problems with the configuration of p.
+func (p *RestartPolicy) validate() []error {
+	var errs []error
+	if p.MaxRestarts < 0 {
+		errs = append(errs, fmt.Errorf("negative restart policy MaxRestarts %v", p.MaxRestarts))
+	}
+	if p.Window < 0 || p.Delay < 0 {
+		errs = append(errs, errors.New("negative restart policy Window or Delay"))
+	}
+	return errs
+}
+
+// WithRunner sets the Runner performing the work of the service.
+func WithRunner(r Runner) Option {
+	return func(s *Service) {
+		s.runner = r
+	}
+}
+
+// WithFactory creates a fresh Runner with factory for each start of the service, so no state
+// leaks between restarts.  An error from factory fails that start.  Interfaces of the Runner,
+// such as HealthChecker, apply once it has been created.  Validate creates the Runner of the next
+// start in advance, so it is validated like any other.
+func WithFactory(factory func() (Runner, error)) Option {
+	return func(s *Service) {
+		s.factory = factory
+	}
+}
+
+// WithStartTimeout limits how long the Runner's Starter may take, the service fails with
+// ErrStartTimeout if it is exceeded.
+func WithStartTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.startTimeout = d
+	}
+}
+
+// WithStopTimeout limits how long the supervisor waits for the service to exit once stopped
+// during shutdown, before abandoning it with ErrStopTimeout.
+func WithStopTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.stopTimeout = d
+	}
+}
+
+// WithRestartPolicy limits how often the supervisor restarts the service after failures,
+// overriding WithDefaultRestartPolicy.  Without either restarts are only limited by the
+// supervisor's restart budget.
+func WithRestartPolicy(p RestartPolicy) Option {
+	return func(s *Service) {
+		s.policy = &p
+	}
+}
+
+// WithBackoff sets how long the supervisor waits before restarting the service after each
+// failure, overriding the Delay of its RestartPolicy and WithDefaultBackoff.
+func WithBackoff(b Backoff) Option {
+	return func(s *Service) {
+		s.backoff = b
+	}
+}
+
+// WithRestartBucket draws restarts of the service after failures from b, which may be shared
+// with other services forming a group.
+func WithRestartBucket(b *RestartBucket) Option {
+	return func(s *Service) {
+		s.bucket = b
+	}
+}
+
+// WithMinUptime fails a run of the service with ErrMinUptime if it exits on its own, without
+// an error, before it has been ready for d, so a service returning immediately is restarted
+// with backoff and limited by its RestartPolicy rather than exiting quietly.
+func WithMinUptime(d time.Duration) Option {
+	return func(s *Service) {
+		s.minUptime = d
+	}
+}
+
+// WithMaxLifetime gracefully restarts the service once it has been running for about d, to
+// mitigate slow leaks, e.g. in long-lived connections held by third-party clients.  The restart
+// is brought forward by up to a tenth of d, so replicas are not recycled together.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(s *Service) {
+		s.maxLifetime = d
+	}
+}
+
+// WithoutRestartBudget exempts the service from the supervisor's restart budget, so a flaky
+// optional service cannot exhaust it, leaving its restarts limited by its RestartPolicy.
+func WithoutRestartBudget() Option {
+	return func(s *Service) {
+		s.unbudgeted = true
+	}
+}
+
+// WithDecider chooses the response to each failure of the service with decide, overriding the
+// supervisor's WithFailureDecider, e.g. to escalate any failure of a critical listener.
+func WithDecider(decide FailureDecider) Option {
+	return func(s *Service) {
+		s.decider = decide
+	}
+}
+
+// WithMiddleware wraps the Runner of the service with mw, the first being outermost, inside any
+// middleware added to the supervisor with Use.
+func WithMiddleware(mw ...Middleware) Option {
+	return func(s *Service) {
+		s.middleware = append(s.middleware, mw...)
+	}
+}
+
+// WithEnabled only runs the service while enabled returns true, e.g. for a feature flag.  It is
+// evaluated as the supervisor starts, and again by Reconcile.  A service which is not enabled is
+// reported as StateDisabled rather than failed.
+func WithEnabled(enabled func() bool) Option {
+	return func(s *Service) {
+		s.enabled = enabled
+	}
+}
+
+// WithLazy defers starting the service until EnsureRunning is called, e.g. for rarely used
+// components like an admin console.  From then on the supervisor manages it as usual.
+func WithLazy() Option {
+	return func(s *Service) {
+		s.lazy = true
+	}
+}
+
+// WithIdleTimeout stops the service once it has been inactive for d, starting it again on the
+// next EnsureRunning.  Activity is recorded by Touch, EnsureRunning, or reported by a Runner
+// implementing ActivityReporter.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.idleTimeout = d
+	}
+}
+
+// WithRequires starts the service only once the named services are running and ready, and stops
+// it before them.  A name may be that of a service, a service created WithReplicas, requiring all
+// of its replicas, or one declared WithProvides.  Names are resolved when the supervisor is run.
+func WithRequires(names ...string) Option {
+	return func(s *Service) {
+		s.requires = append(s.requires, names...)
+	}
+}
+
+// WithWants starts the service after the named services if they are registered and starting,
+// without requiring them: it is started regardless once they are running, have failed, or if
+// they are not registered at all.  Suitable for optional telemetry or caches.
+func WithWants(names ...string) Option {
+	return func(s *Service) {
+		s.wants = append(s.wants, names...)
+	}
+}
+
+// WithProvides declares names, e.g. "cache", that other services may require or want,
+// rather than naming this service.
+func WithProvides(names ...string) Option {
+	return func(s *Service) {
+		s.provides = append(s.provides, names...)
+	}
+}
+
+// WithPreStartCheck adds checks of the environment of the service, e.g. CheckPortFree, run in
+// parallel with those of all other services before Run starts anything.
+func WithPreStartCheck(checks ...PreStartCheck) Option {
+	return func(s *Service) {
+		s.preStart = append(s.preStart, checks...)
+	}
+}
+
+// WithVerify verifies the service once its Starter has returned, e.g. with a request to its own
+// endpoint, while Run executes.  The service is only ready once check passes, which is retried
+// until its start timeout, by default 5s.  If it does not pass the run fails with ErrVerify,
+// subject to the restart policy, rather than claiming readiness while half-broken.
+func WithVerify(check HealthCheck) Option {
+	return func(s *Service) {
+		s.verify = check
+	}
+}
+
+// WithMaxTasks limits the service to n concurrent tasks started by Go.
+func WithMaxTasks(n int) Option {
+	return func(s *Service) {
+		s.maxTasks = n
+	}
+}
+
+// WithReplicas runs n identical instances of the service, named with an index suffix, e.g. a-0
+// to a-3.  Each is restarted independently, see Supervisor.ReplicaSet for their aggregate
+// state.  The Runner is shared, and called concurrently by the replicas; FromContext(ctx).Replica
+// tells them apart.
+func WithReplicas(n int) Option {
+	return func(s *Service) {
+		s.replicas = n
+	}
+}
+
+// WithShards assigns each replica of the service a shard with f, available to its Runner from
+// ShardFromContext.  See Supervisor.Reshard to change the assignments.
+func WithShards(f ShardFunc) Option {
+	return func(s *Service) {
+		s.shards = f
+	}
+}
+
+// WithNonCritical marks the service as non-critical, so that it being unhealthy degrades the
+// Health of the application rather than making it unhealthy.
+func WithNonCritical() Option {
+	return func(s *Service) {
+		s.optional = true
+	}
+}
+
+// WithHealthCheck adds a check run by Health and Readiness while the service is running, in
+// addition to CheckHealth if its Runner is a HealthChecker.
+func WithHealthCheck(check HealthCheck) Option {
+	return func(s *Service) {
+		s.checks = append(s.checks, check)
+	}
+}
+
+// WithLivenessCheck adds a check run by Health and Liveness while the service is running.  See
+// WithLivenessProbe to restart the service when it fails.
+func WithLivenessCheck(check HealthCheck) Option {
+	return func(s *Service) {
+		s.liveness = append(s.liveness, check)
+	}
+}
+
+// WithReadinessCheck adds a check run by Health and Readiness while the service is running.
+func WithReadinessCheck(check HealthCheck) Option {
+	return func(s *Service) {
+		s.readiness = append(s.readiness, check)
+	}
+}
+
+// WithShutdownRank overrides the order the service is stopped in when the supervisor shuts down.
+// Services are stopped in ascending rank, and in reverse start order within a rank, so the
+// default rank of 0 stops everything in reverse start order.  E.g. ingress could be ranked -1 to
+// stop before workers, and storage 1 to stop after them.  A service may not rank above a service
+// it requires, which must outlive it.
+func WithShutdownRank(rank int) Option {
+	return func(s *Service) {
+		s.stopRank = rank
+	}
+}
+
+// WithLogger sets the logger for messages about the service, which defaults to the supervisor's
+// logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Service) {
+		s.logger = l
+	}
+}
+
+// SupervisorOption configures a Supervisor, see NewSupervisor.
+type SupervisorOption func(*Supervisor)
+
+// WithRestartBudget limits the restarts shared by all services to n, once exceeded the next
+// failure shuts down the supervisor with ErrRestartsExhausted.  Without it restarts are only
+// limited by each service's RestartPolicy.  Services may be exempted with WithoutRestartBudget.
+func WithRestartBudget(n int) SupervisorOption {
+	return func(s *Supervisor) {
+		s.budgeted, s.restarts = true, n
+	}
+}
+
+// WithDefaultRestartPolicy limits restarts after failures of services without their own
+// WithRestartPolicy.
+func WithDefaultRestartPolicy(p RestartPolicy) SupervisorOption {
+	return func(s *Supervisor) {
+		s.policy = &p
+	}
+}
+
+// WithDefaultBackoff sets the delay before restarting services without their own WithBackoff or
+// RestartPolicy.
+func WithDefaultBackoff(b Backoff) SupervisorOption {
+	return func(s *Supervisor) {
+		s.backoff = b
+	}
+}
+
+// WithStateFile persists the restart state of services, their recent failures, backoff and
+// whether their restart policy breaker is open, as JSON in path, along with the remaining
+// restart budget.  Run restores it before starting services, and saves it after each change, so
+// crash loop detection survives restarts of the process itself, e.g. by systemd.  A service
+// whose breaker was open is not started until its failures fall outside the policy Window,
+// never if it has none, so remove the file to reset.
+func WithStateFile(path string) SupervisorOption {
+	return func(s *Supervisor) {
+		s.stateFile = path
+	}
+}
+
+// WithRunFor schedules a graceful shutdown once Run has been running for d.
+func WithRunFor(d time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.duration = d
+	}
+}
+
+// WithRunUntil schedules a graceful shutdown at the wall-clock time t.  If both WithRunFor and
+// WithRunUntil are used, whichever comes first wins.
+func WithRunUntil(t time.Time) SupervisorOption {
+	return func(s *Supervisor) {
+		s.deadline = t
+	}
+}
+
+// WithStartupBanner logs build and configuration Info when Run starts.
+func WithStartupBanner() SupervisorOption {
+	return func(s *Supervisor) {
+		s.banner = true
+	}
+}
+
+// WithPIDFile writes the pid of this process to path when Run starts, and removes it when Run
+// returns.  Run fails if path names another running process.
+func WithPIDFile(path string) SupervisorOption {
+	return func(s *Supervisor) {
+		s.pidFile = path
+	}
+}
+
+// WithSingleInstance takes an exclusive lock on the file path before starting any services, held
+// until Run returns.  Run fails with ErrAlreadyRunning if another process holds the lock.
+func WithSingleInstance(path string) SupervisorOption {
+	return func(s *Supervisor) {
+		s.instanceLock = path
+	}
+}
+
+// WithLivenessProbe runs the liveness checks of services every interval, restarting running
+// services which fail them.
+func WithLivenessProbe(interval time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.liveness = interval
+	}
+}
+
+// WithRestartDebounce delays restarts requested by Restart or RestartBy for d, coalescing further
+// requests for the service within it, e.g. from a health check, operator and config change, into a
+// single restart reporting all of their reasons as the Causes of its EventStopped.
+func WithRestartDebounce(d time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.debounce = d
+	}
+}
+
+// WithDrainDelay fails the given admin endpoints, "/readyz" if none, for delay once shutdown
+// begins, before any service is stopped, so load balancers polling them stop routing requests
+// before servers begin draining.  GRPC serving statuses follow "/readyz".
+func WithDrainDelay(delay time.Duration, endpoints ...string) SupervisorOption {
+	return func(s *Supervisor) {
+		if len(endpoints) == 0 {
+			endpoints = []string{"/readyz"}
+		}
+		s.drainDelay, s.drainProbes = delay, endpoints
+	}
+}
+
+// WithEventHook calls hook with every event, in addition to sending it to Events.  Hooks are
+// called synchronously by the supervisor, so must not block; see Webhook.Notify.
+func WithEventHook(hook func(Event)) SupervisorOption {
+	return func(s *Supervisor) {
+		s.hooks = append(s.hooks, hook)
+	}
+}
+
+// WithExitHook calls hook with the error Run is about to return, once all services have
+// stopped, e.g. to flush notifications.  Hooks are called in the order added.
+func WithExitHook(hook func(err error)) SupervisorOption {
+	return func(s *Supervisor) {
+		s.exitHooks = append(s.exitHooks, hook)
+	}
+}
+
+// WithExitCodes sets how Main maps the outcome of Run to an exit code, in place of ExitCode, e.g.
+// to distinguish failure modes in CI.
+func WithExitCodes(code func(ctx context.Context, err error) int) SupervisorOption {
+	return func(s *Supervisor) {
+		s.exitCode = code
+	}
+}
+
+// WithFailureDecider calls decide with each failure of a service to choose the response, e.g.
+// never restarting after an authentication error.  Returning DecideDefault applies the usual
+// handling.  decide is called by the supervisor and must not block.
+func WithFailureDecider(decide FailureDecider) SupervisorOption {
+	return func(s *Supervisor) {
+		s.decider = decide
+	}
+}
+
+// WithCrashLoopHook calls hook, in a new goroutine, when the restart policy breaker of a service
+// opens.  The CrashLoop carries enough context to page someone in a single call.
+func WithCrashLoopHook(hook func(CrashLoop)) SupervisorOption {
+	return func(s *Supervisor) {
+		s.crashHooks = append(s.crashHooks, hook)
+	}
+}
+
+// WithAdminIdentity sets how the AdminHandler identifies the requester of an operator restart
+// for its Audit, e.g. from a verified session cookie, which identify must have authenticated.  It
+// defaults to the name of the authenticated AdminClient, or the remote address.
+func WithAdminIdentity(identify func(*http.Request) string) SupervisorOption {
+	return func(s *Supervisor) {
+		s.adminIdentity = identify
+	}
+}
+
+// WithAdminClients requires requests to the AdminHandler be authenticated as one of clients,
+// by bearer token or client certificate, and allowed the operation requested.  Unauthenticated
+// requests fail with 401 Unauthorized, and operations not allowed with 403 Forbidden.
+func WithAdminClients(clients ...AdminClient) SupervisorOption {
+	return func(s *Supervisor) {
+		s.adminClients = append(s.adminClients, clients...)
+	}
+}
+
+// WithAdminRateLimit limits each AdminClient, or each remote host without WithAdminClients, to
+// burst mutating requests of the AdminHandler, e.g. restarts, at once, and one more every refill
+// thereafter, failing others with 429 Too Many Requests.
+func WithAdminRateLimit(burst int, refill time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		s.adminBurst, s.adminRefill = burst, refill
+	}
+}
+
+// WithProgress calls progress as the services are first started by Run, e.g. for a progress bar
+// or status page, with the number ready so far, the total, and the service that just started or
+// became ready.  Startup is complete once done equals total.  Services held at startup, e.g.
+// WithLazy, are not counted.  progress is called by the supervisor and must not block.
+func WithProgress(progress func(done, total int, current string)) SupervisorOption {
+	return func(s *Supervisor) {
+		s.progress = progress
+	}
+}
+
+// WithChaos injects the faults of c into the services it selects, for resilience testing.  It
+// must not be used in production.
+func WithChaos(c *Chaos) SupervisorOption {
+	return func(s *Supervisor) {
+		s.chaos = c
+		s.middleware = append(s.middleware, c.middleware)
+	}
+}
+
+// WithStopParallelism stops up to n services at once during shutdown, rather than one at a time,
+// so that one slow service does not delay the others.  A service is still only stopped once
+// those of a lower WithShutdownRank, and those requiring it, have exited.
+func WithStopParallelism(n int) SupervisorOption {
+	return func(s *Supervisor) {
+		s.stopParallel = n
+	}
+}
+
+// WithSupervisorLogger sets the logger for the supervisor, and default logger for its services.
+// It defaults to slog.Default().
+func WithSupervisorLogger(l *slog.Logger) SupervisorOption {
+	return func(s *Supervisor) {
+		s.logger = l
+	}
+}
+
+// Validate checks the configuration of the service, and of its Runner if it is a Validator,
+// reporting all problems found.
+func (s *Service) Validate() error {
+	var errs []error
+	if s.name == "" {
+		errs = append(errs, errors.New("service has no name"))
+	}
+	if s.runner == nil && s.factory == nil {
+		errs = append(errs, errNoRunner)
+	}
+	if s.startTimeout < 0 {
+		errs = append(errs, fmt.Errorf("negative start timeout %v", s.startTimeout))
+	}
+	if s.stopTimeout < 0 {
+		errs = append(errs, fmt.Errorf("negative stop timeout %v", s.stopTimeout))
+	}
+	if s.minUptime < 0 {
+		errs = append(errs, fmt.Errorf("negative minimum uptime %v", s.minUptime))
+	}
+	if s.maxLifetime < 0 {
+		errs = append(errs, fmt.Errorf("negative maximum lifetime %v", s.maxLifetime))
+	}
+	if s.idleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("negative idle timeout %v", s.idleTimeout))
+	}
+	if s.replicas < 0 {
+		errs = append(errs, fmt.Errorf("negative replicas %v", s.replicas))
+	}
+	if s.maxTasks < 0 {
+		errs = append(errs, fmt.Errorf("negative max tasks %v", s.maxTasks))
+	}
+	if s.policy != nil {
+		errs = append(errs, s.policy.validate()...)
+	}
+	// A factory failing here is left to fail the start instead, as its failures may be transient.
+	if r, err := s.prepare(); err == nil {
+		if v, ok := r.(Validator); ok {
+			if err := v.Validate(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("service %q: %w", s.name, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration of the supervisor and all its services, reporting all
+// problems found.  Run calls Validate before starting any services.
+func (s *Supervisor) Validate() error {
+	var errs []error
+	if s.budgeted && s.restarts < 0 {
+		errs = append(errs, fmt.Errorf("negative restart budget %v", s.restarts))
+	}
+	if s.policy != nil {
+		errs = append(errs, s.policy.validate()...)
+	}
+	if s.duration < 0 {
+		errs = append(errs, fmt.Errorf("negative run duration %v", s.duration))
+	}
+	if s.stopParallel < 0 {
+		errs = append(errs, fmt.Errorf("negative stop parallelism %v", s.stopParallel))
+	}
+	errs = append(errs, s.validateAdmin()...)
+	if s.debounce < 0 {
+		errs = append(errs, fmt.Errorf("negative restart debounce %v", s.debounce))
+	}
+	if s.drainDelay < 0 {
+		errs = append(errs, fmt.Errorf("negative drain delay %v", s.drainDelay))
+	}
+	for _, ep := range s.drainProbes {
+		if _, ok := probeEndpoints[ep]; !ok {
+			errs = append(errs, fmt.Errorf("unknown drain endpoint %q", ep))
+		}
+	}
+	if s.liveness < 0 {
+		errs = append(errs, fmt.Errorf("negative liveness probe interval %v", s.liveness))
+	}
+	if s.logger == nil {
+		errs = append(errs, errors.New("nil logger"))
+	}
+	names := make(map[string]bool)
+	for _, svc := range s.list() {
+		if names[svc.name] {
+			errs = append(errs, fmt.Errorf("duplicate service name %q", svc.name))
+		}
+		names[svc.name] = true
+		if err := svc.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if v, ok := s.gates[svc].(Validator); ok {
+			if err := v.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("service %q gate: %w", svc.name, err))
+			}
+		}
+		deps, err := s.requirements(svc)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		for _, dep := range deps {
+			if dep.stopRank < svc.stopRank {
+				errs = append(errs, fmt.Errorf("service %q requires %q, but has a higher shutdown rank",
+					svc.name, dep.name))
+			}
+		}
+	}
+	if err := s.cycles(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("invalid supervisor configuration: %w", err)
+	}
+	return nil
+}
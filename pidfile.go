@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// writePIDFile writes the pid of this process to path.  An existing file is replaced if the
+// process it names is no longer running, otherwise an error is returned.
+func writePIDFile(path string) error {
+	if pid, err := readPIDFile(path); err == nil && pid != os.Getpid() && processAlive(pid) {
+		return fmt.Errorf("pid file %s: already running as pid %d", path, pid)
+	} else if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644)
+}
+
+// removePIDFile removes path, if it still contains the pid of this process.  A file already gone
+// is not an error.
+func removePIDFile(path string) error {
+	if pid, err := readPIDFile(path); err != nil || pid != os.Getpid() {
+		return ignoreNotExist(err)
+	}
+	return ignoreNotExist(os.Remove(path))
+}
+
+// readPIDFile returns the pid stored in path.  A file not containing a pid is treated as stale,
+// returning pid 0.
+func readPIDFile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(b)))
+	if err != nil {
+		return 0, nil
+	}
+	return pid, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+// Plan is how Run would start and stop the registered services, see Supervisor.Plan.
+type Plan struct {
+	Start    [][]PlanStep `json:"start"`    // Phases, each started once those before it are ready.
+	Shutdown []string     `json:"shutdown"` // Services in the order they begin stopping.
+}
+
+// PlanStep is the start of a single service, with the services it waits for.
+type PlanStep struct {
+	Service  string   `json:"service"`
+	Requires []string `json:"requires,omitempty"` // Waited for until running and ready.
+	Wants    []string `json:"wants,omitempty"`    // Waited for only while starting.
+}
+
+// Plan returns the start and shutdown plan of Run without starting anything, so that tests and
+// operators can check the topology.  Services in the same phase are started in parallel.  The
+// error is that of Validate, which Run would fail with.
+func (s *Supervisor) Plan() (Plan, error) {
+	if err := s.Validate(); err != nil {
+		return Plan{}, err
+	}
+	var plan Plan
+	for _, phase := range s.phases() {
+		steps := make([]PlanStep, 0, len(phase))
+		for _, svc := range phase {
+			step := PlanStep{Service: svc.name}
+			deps, _ := s.requirements(svc)
+			for _, dep := range deps {
+				step.Requires = append(step.Requires, dep.name)
+			}
+			for _, dep := range s.soft(svc) {
+				step.Wants = append(step.Wants, dep.name)
+			}
+			steps = append(steps, step)
+		}
+		plan.Start = append(plan.Start, steps)
+	}
+	for _, svc := range s.shutdownOrder(s.list()) {
+		plan.Shutdown = append(plan.Shutdown, svc.name)
+	}
+	return plan, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// PoolShutdownRank is the shutdown rank for a Pool, see WithShutdownRank, stopping it after the
+// services using it, but before the log file.
+const PoolShutdownRank = math.MaxInt - 1
+
+// Pinger is a connection pool or client, e.g. a *sql.DB, checked with PingContext and closed at
+// shutdown.  Clients with other method names, e.g. redis, are adapted with a small wrapper.
+type Pinger interface {
+	PingContext(ctx context.Context) error
+	Close() error
+}
+
+// Pool is a Runner owning a connection pool: it is only ready once a ping succeeds, pings the
+// pool every interval for its health and readiness, and closes it when stopped.  Register it
+// WithShutdownRank(PoolShutdownRank) so the pool is closed after the services using it exit.
+type Pool[P Pinger] struct {
+	open     func(ctx context.Context) (P, error)
+	interval time.Duration
+
+	mu   sync.Mutex // Protects the fields below.
+	pool P
+	ok   bool // Whether pool is open.
+	err  error
+}
+
+// NewPool creates a Pool opening its pool with open, which is called for each run as a closed
+// pool cannot be reused.  The pool is pinged every interval.
+func NewPool[P Pinger](open func(ctx context.Context) (P, error), interval time.Duration) *Pool[P] {
+	return &Pool[P]{open: open, interval: interval}
+}
+
+// Validate implements Validator, checking the ping interval is positive.
+func (p *Pool[P]) Validate() error {
+	if p.interval <= 0 {
+		return fmt.Errorf("non-positive pool ping interval %v", p.interval)
+	}
+	return nil
+}
+
+// Start implements Starter, opening the pool and pinging it every interval until a ping
+// succeeds, or ctx is done.
+func (p *Pool[P]) Start(ctx context.Context) error {
+	pool, err := p.open(ctx)
+	if err != nil {
+		return fmt.Errorf("open: %w", err)
+	}
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err = pool.PingContext(ctx); err == nil {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			pool.Close()
+			return fmt.Errorf("ping: %w", err)
+		}
+	}
+	p.mu.Lock()
+	p.pool, p.ok, p.err = pool, true, nil
+	p.mu.Unlock()
+	return nil
+}
+
+// Run implements Runner, pinging the pool every interval until ctx is done, and then closing it.
+func (p *Pool[P]) Run(ctx context.Context) error {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	p.mu.Lock()
+	pool := p.pool
+	p.mu.Unlock()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			p.mu.Lock()
+			var zero P
+			p.pool, p.ok, p.err = zero, false, nil
+			p.mu.Unlock()
+			return pool.Close()
+		}
+		pctx, cancel := context.WithTimeout(ctx, p.interval)
+		err := pool.PingContext(pctx)
+		cancel()
+		if ctx.Err() != nil {
+			continue
+		}
+		p.mu.Lock()
+		if err != nil && p.err == nil {
+			LoggerFromContext(ctx).Warn("pool ping failed", "err", err)
+		}
+		p.err = err
+		p.mu.Unlock()
+	}
+}
+
+// Get returns the open pool, for the services using it, or false if it is not open.
+func (p *Pool[P]) Get() (P, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pool, p.ok
+}
+
+// CheckHealth implements HealthChecker, failing with the error of the last ping.
+func (p *Pool[P]) CheckHealth(context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return fmt.Errorf("ping: %w", p.err)
+	}
+	return nil
+}
+
+// CheckReadiness implements ReadinessChecker, failing until the pool is open, and then with the
+// error of the last ping.
+func (p *Pool[P]) CheckReadiness(ctx context.Context) error {
+	p.mu.Lock()
+	ok := p.ok
+	p.mu.Unlock()
+	if !ok {
+		return errors.New("pool not open")
+	}
+	return p.CheckHealth(ctx)
+}
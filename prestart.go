@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// PreStartChecker may be implemented by a Runner to probe its environment, e.g. that its port is
+// free, before the supervisor starts any services.  The Runner of a service with a factory is
+// created in advance to be checked, and then used for its first start.
+type PreStartChecker interface {
+	PreStartCheck(ctx context.Context) error
+}
+
+// PreStartCheck probes the environment of a service, see WithPreStartCheck.
+type PreStartCheck func(ctx context.Context) error
+
+// CheckPortFree is a PreStartCheck that addr, e.g. ":8080", can be listened on.
+func CheckPortFree(addr string) PreStartCheck {
+	return func(ctx context.Context) error {
+		l, err := new(net.ListenConfig).Listen(ctx, "tcp", addr)
+		if err != nil {
+			return fmt.Errorf("port not free: %w", err)
+		}
+		return l.Close()
+	}
+}
+
+// CheckWritable is a PreStartCheck that files can be created in dir.
+func CheckWritable(dir string) PreStartCheck {
+	return func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".prestart-*")
+		if err != nil {
+			return fmt.Errorf("directory not writable: %w", err)
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// CheckEnv is a PreStartCheck that the environment variables names, e.g. credentials, are set
+// and not empty.
+func CheckEnv(names ...string) PreStartCheck {
+	return func(ctx context.Context) error {
+		var missing []string
+		for _, name := range names {
+			if os.Getenv(name) == "" {
+				missing = append(missing, name)
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("environment variables not set: %v", missing)
+		}
+		return nil
+	}
+}
+
+// preStart runs the pre-start checks of all services in parallel, joining all failures.
+func (s *Supervisor) preStart(ctx context.Context) error {
+	type job struct {
+		svc   *Service
+		check PreStartCheck
+	}
+	var jobs []job
+	for _, svc := range s.list() {
+		for _, check := range svc.preStart {
+			jobs = append(jobs, job{svc, check})
+		}
+		// A factory failing is left to fail the start, as for Validate.
+		r, _ := svc.prepare()
+		if c, ok := r.(PreStartChecker); ok {
+			jobs = append(jobs, job{svc, c.PreStartCheck})
+		}
+	}
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := j.check(ctx); err != nil {
+				errs[i] = fmt.Errorf("service %q: %w", j.svc.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("pre-start checks failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !unix && !windows
+
+package main
+
+// processAlive reports whether a process with pid exists.  Not supported on this platform, so a
+// pid file is always considered stale.
+func processAlive(pid int) bool {
+	return false
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Process is a Runner executing an external command, letting the supervisor manage other
+// components like a minimal procfile runner.  The command is sent a termination signal when its
+// service is stopped.  Its output is logged line by line, tagged with the service name.
+type Process struct {
+	path     string
+	args     []string
+	dir      string
+	env      []string    // Variables added to the environment, as key=value.
+	inherit  []string    // Variables inherited from our environment, all if nil.
+	exclude  []string    // Variables not inherited from our environment.
+	umask    os.FileMode // Applied to the command if hasUmask.
+	hasUmask bool
+	output   io.Writer // Receives output lines instead of the service logger, if set.
+	json     bool      // Detect JSON output lines.
+}
+
+// ProcessOption configures a Process, see NewProcess.
+type ProcessOption func(*Process)
+
+// NewProcess creates a Runner executing path with args, configured by opts.
+func NewProcess(path string, args []string, opts ...ProcessOption) *Process {
+	p := &Process{path: path, args: args}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// WithDir sets the working directory of the command, which defaults to our own.
+func WithDir(dir string) ProcessOption {
+	return func(p *Process) {
+		p.dir = dir
+	}
+}
+
+// WithEnv adds variables, formatted as key=value, to the environment of the command.  They
+// override inherited variables of the same name.
+func WithEnv(env ...string) ProcessOption {
+	return func(p *Process) {
+		p.env = append(p.env, env...)
+	}
+}
+
+// WithInheritEnv limits the variables the command inherits from our environment to names.
+func WithInheritEnv(names ...string) ProcessOption {
+	return func(p *Process) {
+		p.inherit = append(p.inherit, names...)
+	}
+}
+
+// WithExcludeEnv prevents the command from inheriting the named variables from our environment.
+func WithExcludeEnv(names ...string) ProcessOption {
+	return func(p *Process) {
+		p.exclude = append(p.exclude, names...)
+	}
+}
+
+// WithUmask sets the file mode creation mask of the command, which is started by /bin/sh to set
+// it, so the command fails to start without one.  Ignored on platforms without a umask, e.g.
+// Windows.
+func WithUmask(mask os.FileMode) ProcessOption {
+	return func(p *Process) {
+		p.umask, p.hasUmask = mask, true
+	}
+}
+
+// WithOutput writes the output lines of the command to w, prefixed with the service name, instead
+// of logging them.
+func WithOutput(w io.Writer) ProcessOption {
+	return func(p *Process) {
+		p.output = w
+	}
+}
+
+// WithJSONLines detects output lines which are JSON objects, e.g. from a command using a
+// structured logger.  They are logged with their msg, level and other fields, or written to the
+// WithOutput writer unprefixed.
+func WithJSONLines() ProcessOption {
+	return func(p *Process) {
+		p.json = true
+	}
+}
+
+// processWaitDelay is how long a stopped Process is waited for before it is killed, if its
+// service has no stop timeout.
+const processWaitDelay = 10 * time.Second
+
+// Run executes the command until it exits or ctx is canceled.  An exit caused by canceling ctx
+// is not an error.  Once ctx is canceled the command is killed, and its output closed, if it has
+// not exited within the stop timeout of its service.
+func (p *Process) Run(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, p.path, p.args...)
+	cmd.Dir = p.dir
+	cmd.Env = p.environ()
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(stopSignal)
+	}
+	cmd.WaitDelay = processWaitDelay
+	name := "process"
+	if svc := FromContext(ctx); svc != nil {
+		name = svc.Name()
+		if svc.stopTimeout > 0 {
+			cmd.WaitDelay = svc.stopTimeout
+		}
+	}
+	out := &processOutput{p: p, name: name, logger: LoggerFromContext(ctx)}
+	stdout, stderr := &lineWriter{out: out, stream: "stdout"}, &lineWriter{out: out, stream: "stderr"}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := p.start(cmd); err != nil {
+		return err
+	}
+	err := cmd.Wait()
+	stdout.flush()
+	stderr.flush()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// start starts cmd, with our umask if set.
+func (p *Process) start(cmd *exec.Cmd) error {
+	if !p.hasUmask {
+		return cmd.Start()
+	}
+	return startWithUmask(cmd, p.umask)
+}
+
+// environ returns the environment for the command.
+func (p *Process) environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if p.inherit != nil && !slices.Contains(p.inherit, name) {
+			continue
+		}
+		if slices.Contains(p.exclude, name) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	// Later duplicates take precedence in exec.Cmd.
+	return append(env, p.env...)
+}
+
+// processOutput routes the output lines of a Process.
+type processOutput struct {
+	p      *Process
+	name   string
+	logger *slog.Logger
+}
+
+// processOutputMu serializes writes to the output of every Process, as replicas of a service, or
+// separate services, may share the writer.
+var processOutputMu sync.Mutex
+
+// maxLineLength limits buffered process output without a newline, beyond which it is split.
+const maxLineLength = 64 << 10
+
+// line routes a single line of output from stream.
+func (o *processOutput) line(stream string, line []byte) {
+	var fields map[string]any
+	isJSON := o.p.json && bytes.HasPrefix(line, []byte("{")) && json.Unmarshal(line, &fields) == nil
+	if o.p.output != nil {
+		processOutputMu.Lock()
+		defer processOutputMu.Unlock()
+		if isJSON {
+			fmt.Fprintf(o.p.output, "%s\n", line)
+		} else {
+			fmt.Fprintf(o.p.output, "%s: %s\n", o.name, line)
+		}
+		return
+	}
+	level := slog.LevelInfo
+	if stream == "stderr" {
+		level = slog.LevelWarn
+	}
+	if !isJSON {
+		o.logger.Log(context.Background(), level, string(line), "service", o.name, "stream", stream)
+		return
+	}
+	msg, _ := fields["msg"].(string)
+	if s, ok := fields["level"].(string); ok {
+		_ = level.UnmarshalText([]byte(s))
+	}
+	delete(fields, "msg")
+	delete(fields, "level")
+	delete(fields, "time")
+	args := []any{"service", o.name, "stream", stream}
+	for _, k := range slices.Sorted(maps.Keys(fields)) {
+		args = append(args, k, fields[k])
+	}
+	o.logger.Log(context.Background(), level, msg, args...)
+}
+
+// lineWriter splits output from stream into lines, of at most maxLineLength.
+type lineWriter struct {
+	out    *processOutput
+	stream string
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 || i > maxLineLength {
+			if len(w.buf) < maxLineLength {
+				break
+			}
+			w.out.line(w.stream, w.buf[:maxLineLength])
+			w.buf = w.buf[maxLineLength:]
+			continue
+		}
+		w.out.line(w.stream, bytes.TrimSuffix(w.buf[:i], []byte("\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush routes any final unterminated line.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.out.line(w.stream, w.buf)
+		w.buf = nil
+	}
+}
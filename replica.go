@@ -0,0 +1,73 @@
+package main
+
+import "fmt"
+
+// ReplicaSet summarizes the replicas of a service created WithReplicas.
+type ReplicaSet struct {
+	Name     string
+	Replicas []ServiceSnapshot
+	Running  int // Replicas in StateRunning.
+	Failed   int // Replicas in StateFailed.
+}
+
+// Healthy reports whether all replicas are running.
+func (rs ReplicaSet) Healthy() bool {
+	return rs.Running == len(rs.Replicas)
+}
+
+// Group returns the name of the service this is a replica of, or its own name if it is not
+// replicated.
+func (s *Service) Group() string {
+	if s.group == "" {
+		return s.name
+	}
+	return s.group
+}
+
+// Replica returns the index of this replica within its group, 0 if it is not replicated.
+func (s *Service) Replica() int {
+	return s.replica
+}
+
+// replicate expands svc into its replicas, named with an index suffix.  Replicas share the
+// Runner of svc, which will be called concurrently.
+func replicate(svc *Service) []*Service {
+	if svc.replicas <= 1 {
+		if svc.shards != nil {
+			shard := svc.shards(0, 1)
+			svc.shard = &shard
+		}
+		return []*Service{svc}
+	}
+	svcs := make([]*Service, svc.replicas)
+	for i := range svcs {
+		r := svc.withRunner(svc.runner)
+		r.name = fmt.Sprintf("%s-%d", svc.name, i)
+		r.group, r.replica = svc.name, i
+		if svc.shards != nil {
+			shard := svc.shards(i, svc.replicas)
+			r.shard = &shard
+		}
+		svcs[i] = r
+	}
+	return svcs
+}
+
+// ReplicaSet returns the aggregate state of the replicas of the named service.
+func (s *Supervisor) ReplicaSet(name string) (ReplicaSet, bool) {
+	rs := ReplicaSet{Name: name}
+	for _, svc := range s.list() {
+		if svc.Group() != name {
+			continue
+		}
+		snap := svc.Snapshot()
+		rs.Replicas = append(rs.Replicas, snap)
+		switch snap.State {
+		case StateRunning:
+			rs.Running++
+		case StateFailed:
+			rs.Failed++
+		}
+	}
+	return rs, len(rs.Replicas) > 0
+}
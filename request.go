@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// NewRequestContext returns a context for a request handled by the service, canceled when
+// either parent is done or the service is stopping.  The request is counted as outstanding until
+// the returned CancelFunc is called, as if by Track and Done.
+func (s *Service) NewRequestContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	s.mu.Lock()
+	sctx := s.ctx
+	s.mu.Unlock()
+	s.Track()
+	stop := func() bool { return false }
+	if sctx != nil {
+		stop = context.AfterFunc(sctx, cancel)
+	} else {
+		// Never started, nothing to handle the request.
+		cancel()
+	}
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			stop()
+			cancel()
+			s.Done()
+		})
+	}
+}
+
+// Track registers a unit of in-flight work, which must be finished by calling Done.  Once Run
+// returns, the service does not report its exit until all tracked work is done.
+func (s *Service) Track() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.outstanding++
+	if s.outstanding == 1 {
+		s.idle = make(chan struct{})
+	}
+}
+
+// Done finishes a unit of work registered with Track.
+func (s *Service) Done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outstanding == 0 {
+		panic("service Done called without Track")
+	}
+	s.outstanding--
+	if s.outstanding == 0 {
+		close(s.idle)
+	}
+}
+
+// Outstanding returns the number of requests and other tracked work not yet done.
+func (s *Service) Outstanding() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.outstanding
+}
+
+// Drain waits until there is no outstanding work, or ctx is done.
+func (s *Service) Drain(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		n, idle := s.outstanding, s.idle
+		s.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return fmt.Errorf("%d in-flight: %w", n, context.Cause(ctx))
+		}
+	}
+}
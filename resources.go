@@ -0,0 +1,62 @@
+package main
+
+import (
+	"expvar"
+	"runtime"
+)
+
+// ResourceStats are resource usage figures for a service, or the whole process.
+type ResourceStats struct {
+	Goroutines int    `json:"goroutines"`
+	Allocs     uint64 `json:"allocs"`      // Cumulative count of heap objects allocated.
+	AllocBytes uint64 `json:"alloc_bytes"` // Cumulative bytes allocated for heap objects.
+}
+
+// ResourceReporter may be implemented by a Runner to report the resources used by its service,
+// helping to attribute goroutine or allocation leaks to a particular service.
+type ResourceReporter interface {
+	ResourceStats() ResourceStats
+}
+
+// ResourceUsage aggregates reported resource stats.
+type ResourceUsage struct {
+	// Process is the usage of the whole process, from the runtime.
+	Process ResourceStats `json:"process"`
+	// Reported is the sum of all reporting services.
+	Reported ResourceStats `json:"reported"`
+	// Services are the services implementing ResourceReporter.
+	Services map[string]ResourceStats `json:"services"`
+	// Unreported is the number of process goroutines not claimed by any service.
+	Unreported int `json:"unreported_goroutines"`
+}
+
+// Resources collects resource stats from all services implementing ResourceReporter.
+func (s *Supervisor) Resources() ResourceUsage {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	u := ResourceUsage{
+		Process: ResourceStats{
+			Goroutines: runtime.NumGoroutine(),
+			Allocs:     m.Mallocs,
+			AllocBytes: m.TotalAlloc,
+		},
+		Services: make(map[string]ResourceStats),
+	}
+	for _, snap := range s.Snapshot() {
+		if snap.Resources == nil {
+			continue
+		}
+		u.Services[snap.Name] = *snap.Resources
+		u.Reported.Goroutines += snap.Resources.Goroutines
+		u.Reported.Allocs += snap.Resources.Allocs
+		u.Reported.AllocBytes += snap.Resources.AllocBytes
+	}
+	u.Unreported = u.Process.Goroutines - u.Reported.Goroutines
+	return u
+}
+
+// PublishResources publishes Resources as the expvar variable name, served at /debug/vars.  It
+// panics if name is already published.
+func (s *Supervisor) PublishResources(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return s.Resources() }))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// restartPoll is how often a rolling restart checks whether a service has been restarted.
+const restartPoll = time.Millisecond * 50
+
+// Selector chooses services, e.g. for RollingRestart.
+type Selector func(*Service) bool
+
+// ByName selects the named services.
+func ByName(names ...string) Selector {
+	return func(svc *Service) bool {
+		return slices.Contains(names, svc.Name())
+	}
+}
+
+// ByGroup selects the replicas of the named service, see WithReplicas.
+func ByGroup(name string) Selector {
+	return func(svc *Service) bool {
+		return svc.Group() == name
+	}
+}
+
+// RollingOptions configures RollingRestart.
+type RollingOptions struct {
+	Batch   int           // Services restarted at once, defaults to 1.
+	Timeout time.Duration // Limit on each batch becoming ready again, zero for none.
+}
+
+// RollingRestart restarts the running services matching sel, opts.Batch at a time in
+// registration order, waiting for each batch to become ready before restarting the next.  It
+// aborts, returning an error, if any service fails to come back.
+func (s *Supervisor) RollingRestart(ctx context.Context, sel Selector, opts RollingOptions) error {
+	var svcs []*Service
+	for _, svc := range s.list() {
+		if state := svc.Snapshot().State; state == StateRunning && sel(svc) {
+			svcs = append(svcs, svc)
+		}
+	}
+	batch := max(opts.Batch, 1)
+	for len(svcs) > 0 {
+		n := min(batch, len(svcs))
+		if err := s.restartBatch(ctx, svcs[:n], opts.Timeout); err != nil {
+			return fmt.Errorf("rolling restart aborted: %w", err)
+		}
+		svcs = svcs[n:]
+	}
+	return nil
+}
+
+// restartBatch restarts svcs, waiting for them all to become ready.
+func (s *Supervisor) restartBatch(ctx context.Context, svcs []*Service,
+	timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	runs := make([]int, len(svcs))
+	for i, svc := range svcs {
+		svc.mu.Lock()
+		runs[i] = svc.runs
+		svc.mu.Unlock()
+		if err := s.Restart(svc.Name(), ReasonRolling); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for i, svc := range svcs {
+		if err := svc.waitRestarted(ctx, runs[i]); err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", svc.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// waitRestarted waits for a run after the runs'th to become ready.
+func (s *Service) waitRestarted(ctx context.Context, runs int) error {
+	for {
+		s.mu.Lock()
+		current, ready, done := s.runs, s.ready, s.done
+		s.mu.Unlock()
+		if current <= runs {
+			select {
+			case <-time.After(restartPoll):
+				continue
+			case <-ctx.Done():
+				return fmt.Errorf("not restarted: %w", ctx.Err())
+			}
+		}
+		select {
+		case <-ready:
+			return nil
+		case <-done:
+			if err := s.Err(); err != nil {
+				return err
+			}
+			return errors.New("exited before becoming ready")
+		case <-ctx.Done():
+			return fmt.Errorf("not ready: %w", ctx.Err())
+		}
+	}
+}
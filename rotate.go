@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// RotateOptions configure a RotatingFile.
+type RotateOptions struct {
+	MaxSize int64         // Rotate before a write would exceed this many bytes, zero for no limit.
+	MaxAge  time.Duration // Rotate on the first write this long after opening, zero for no limit.
+	Backups int           // Rotated files kept, as path.1 (newest) to path.N, one if zero.
+	Signals []os.Signal   // Reopen the file on these, e.g. SIGHUP after an external rotation.
+}
+
+// RotatingFile is an io.Writer appending to a log file, rotating it by size and age, for use as
+// the sink of the supervisor's logger where there is no logrotate, e.g. in containers.  Run it as
+// a service to reopen the file on RotateOptions.Signals, and close it at shutdown.  It is opened on
+// the first write, and again on the next write after Close.
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu     sync.Mutex // Protects the fields below, and serializes writes.
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewRotatingFile creates a RotatingFile writing to path, configured by opts.
+func NewRotatingFile(path string, opts RotateOptions) *RotatingFile {
+	return &RotatingFile{path: path, opts: opts}
+}
+
+// Write implements io.Writer, opening or rotating the file first as needed.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil && r.due(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Run implements Runner, reopening the file on each of our signals until ctx is done, and then
+// closing it.
+func (r *RotatingFile) Run(ctx context.Context) error {
+	defer r.Close()
+	if len(r.opts.Signals) == 0 {
+		<-ctx.Done()
+		return nil
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, r.opts.Signals...)
+	defer signal.Stop(sigc)
+	for {
+		select {
+		case <-sigc:
+			if err := r.Reopen(); err != nil {
+				LoggerFromContext(ctx).Error("failed to reopen log file", "path", r.path, "err", err)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Reopen closes and reopens the file, so writes go to a new file at path if it was moved.
+func (r *RotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	return r.open()
+}
+
+// Rotate renames the file to path.1, shifting older backups up, and reopens path.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.rotate(); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
+
+// due reports whether the file must be rotated before writing n more bytes.  It must be called
+// with mu held.
+func (r *RotatingFile) due(n int64) bool {
+	return (r.opts.MaxSize > 0 && r.size > 0 && r.size+n > r.opts.MaxSize) ||
+		(r.opts.MaxAge > 0 && time.Since(r.opened) >= r.opts.MaxAge)
+}
+
+// open opens the file for appending.  It must be called with mu held.
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+// rotate closes the file and shifts it into the backups, removing the oldest.  It must be called
+// with mu held.
+func (r *RotatingFile) rotate() error {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	backups := max(r.opts.Backups, 1)
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", r.path, i) }
+	if err := ignoreNotExist(os.Remove(backup(backups))); err != nil {
+		return err
+	}
+	for i := backups - 1; i >= 1; i-- {
+		if err := ignoreNotExist(os.Rename(backup(i), backup(i+1))); err != nil {
+			return err
+		}
+	}
+	return ignoreNotExist(os.Rename(r.path, backup(1)))
+}
+
+// ignoreNotExist returns err, or nil if it reports a file does not exist.
+func ignoreNotExist(err error) error {
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
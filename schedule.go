@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// windowInterval is how often the supervisor checks maintenance windows.
+const windowInterval = time.Second * 15
+
+// Window is a daily period, in local time, during which a service is stopped for maintenance.
+type Window struct {
+	Start time.Duration // Offset from midnight.
+	End   time.Duration // Offset from midnight, may be before Start to span midnight.
+}
+
+// Daily creates a window between two "15:04" clock times, e.g. Daily("02:00", "02:30").
+func Daily(start, end string) (Window, error) {
+	var w Window
+	for _, c := range []struct {
+		clock string
+		off   *time.Duration
+	}{{start, &w.Start}, {end, &w.End}} {
+		t, err := time.Parse("15:04", c.clock)
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid maintenance window time %q: %w", c.clock, err)
+		}
+		*c.off = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return w, nil
+}
+
+// contains reports whether t falls within the window.
+func (w Window) contains(t time.Time) bool {
+	y, m, d := t.Date()
+	off := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.Start <= w.End {
+		return off >= w.Start && off < w.End
+	}
+	return off >= w.Start || off < w.End
+}
+
+func (w Window) String() string {
+	midnight := time.Time{}
+	return midnight.Add(w.Start).Format("15:04") + "-" + midnight.Add(w.End).Format("15:04")
+}
+
+// inWindow reports whether t falls within any of windows.
+func inWindow(windows []Window, t time.Time) bool {
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedule stops the named service during each of windows, and starts it again afterwards.
+// Scheduled stops are reported with ReasonMaintenance, and are not treated as failures.
+// Schedule must not be called once Run has been called.
+func (s *Supervisor) Schedule(name string, windows ...Window) error {
+	svc := s.service(name)
+	if svc == nil {
+		return fmt.Errorf("unknown service %q", name)
+	}
+	if s.windows == nil {
+		s.windows = make(map[*Service][]Window)
+	}
+	s.windows[svc] = append(s.windows[svc], windows...)
+	return nil
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"runtime/pprof"
+	"sync"
+	"time"
+)
+
+// lifetimeJitter divides the maximum lifetime of a service, giving the most each run may be
+// shortened by at random, see WithMaxLifetime.
+const lifetimeJitter = 10
+
+// errNoRunner is the failure of a service created without WithRunner or WithFactory.
+var errNoRunner = errors.New("no Runner configured")
+
+// ErrMinUptime is the failure of a service exiting on its own before its minimum uptime, see
+// WithMinUptime.
+var ErrMinUptime = errors.New("exited before minimum uptime")
+
+// State is the lifecycle state of a service.
+type State string
+
+const (
+	StateIdle     State = "idle"     // Never started, or stopped while idle.
+	StateStarting State = "starting" // Starter.Start is executing.
+	StateRunning  State = "running"  // Run is executing.
+	StateStopping State = "stopping" // Stop requested, waiting for run to return.
+	StateStopped  State = "stopped"  // Run returned without error.
+	StateFailed   State = "failed"   // Start or Run returned an error.
+	StatePaused   State = "paused"   // Held by a maintenance window or gate, will start again.
+	StateDisabled State = "disabled" // Not enabled, see WithEnabled.
+)
+
+// StopReason records why a service stopped.
+type StopReason string
+
+const (
+	ReasonNone          StopReason = ""               // Service has not stopped.
+	ReasonExited        StopReason = "exited"         // Run returned without being asked to.
+	ReasonFailed        StopReason = "failed"         // Run, or a task started by Go, failed.
+	ReasonOperator      StopReason = "operator"       // Stop was called directly.
+	ReasonSignal        StopReason = "signal"         // The process received a shutdown signal.
+	ReasonRestartPolicy StopReason = "restart-policy" // The supervisor gave up restarting failures.
+	ReasonShutdown      StopReason = "shutdown"       // The parent supervisor is shutting down.
+	ReasonMemory        StopReason = "memory"         // Restarted by the memory watchdog.
+	ReasonDeadline      StopReason = "deadline"       // The supervisor reached its scheduled end.
+	ReasonMaintenance   StopReason = "maintenance"    // A scheduled maintenance window began.
+	ReasonGate          StopReason = "gate"           // The service's Gate, e.g. leadership, was lost.
+	ReasonConfig        StopReason = "config"         // Restarted to pick up new configuration.
+	ReasonUpgrade       StopReason = "upgrade"        // The process is being replaced by Upgrade.
+	ReasonSwap          StopReason = "swap"           // Replaced by a new instance, see Swap.
+	ReasonRolling       StopReason = "rolling"        // Restarted by RollingRestart.
+	ReasonReshard       StopReason = "reshard"        // The replica's shard was reassigned.
+	ReasonFileChange    StopReason = "file-change"    // Restarted by a DevWatcher.
+	ReasonLiveness      StopReason = "liveness"       // A liveness check failed.
+	ReasonDisabled      StopReason = "disabled"       // The service is no longer enabled.
+	ReasonIdle          StopReason = "idle"           // No activity within the idle timeout.
+	ReasonFatal         StopReason = "fatal"          // A service failed with a Fatal error.
+	ReasonRecycle       StopReason = "recycle"        // Restarted after its maximum lifetime.
+)
+
+// ExitError is the final error of a service, recording why it stopped.
+type ExitError struct {
+	Name   string
+	Reason StopReason
+	Err    error
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("service %s stopped (%s): %v", e.Name, e.Reason, e.Err)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ServiceSnapshot is a point in time view of a service.
+type ServiceSnapshot struct {
+	Name      string
+	State     State
+	Reason    StopReason     // Why the service last stopped, empty while running.
+	Err       error          // Final error from the last run, if any.
+	Since     time.Time      // When State was entered.
+	Run       int            // Generation of the latest run, see GenerationFromContext.
+	Resources *ResourceStats // Set if the Runner implements ResourceReporter.
+}
+
+// Runner performs the work of a service until ctx is canceled.
+type Runner interface {
+	Run(ctx context.Context) error
+}
+
+// Starter may be implemented by a Runner that must prepare before it runs, e.g. to open a
+// listener or connect to a database.  Start is bounded by the service's start timeout, and the
+// service is considered ready once it returns.  Once Start succeeds Run is always called, with
+// its context already done if the service was stopped while starting, so it can release what
+// Start acquired.
+type Starter interface {
+	Start(ctx context.Context) error
+}
+
+// Validator may be implemented by a Runner to check its own configuration, called by
+// Service.Validate before the supervisor starts anything.
+type Validator interface {
+	Validate() error
+}
+
+// RunFunc adapts an ordinary function to the Runner interface.
+type RunFunc func(ctx context.Context) error
+
+// Run calls f(ctx).
+func (f RunFunc) Run(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Service represents a long running service in our application.
+type Service struct {
+	name         string
+	runner       Runner                 // Protected by mu if created by factory.
+	factory      func() (Runner, error) // Creates runner for each run, see WithFactory.
+	next         Runner                 // Created by factory ahead of the next run, see prepare.
+	startTimeout time.Duration
+	stopTimeout  time.Duration
+	policy       *RestartPolicy
+	logger       *slog.Logger
+	maxTasks     int
+	replicas     int           // Number of replicas Add expands this service into.
+	group        string        // Name of the replicated service, for replicas.
+	replica      int           // Index of this replica.
+	shards       ShardFunc     // Assigns replicas their shards, see WithShards.
+	optional     bool          // Failure only degrades health, see WithNonCritical.
+	checks       []HealthCheck // Health checks, see WithHealthCheck.
+	liveness     []HealthCheck
+	readiness    []HealthCheck
+	stopRank     int     // Shutdown order, see WithShutdownRank.
+	backoff      Backoff // Delay before restarts after failures, see WithBackoff.
+	bucket       *RestartBucket
+	unbudgeted   bool           // Restarts not drawn from the budget, see WithoutRestartBudget.
+	decider      FailureDecider // Overrides the supervisor's, see WithDecider.
+	middleware   []Middleware   // Wraps runner, see WithMiddleware.
+	enabled      func() bool    // Whether the service should run, see WithEnabled.
+	lazy         bool           // Not started until wanted, see WithLazy.
+	idleTimeout  time.Duration  // Stopped after this long without activity, see WithIdleTimeout.
+	minUptime    time.Duration  // Shorter runs fail, see WithMinUptime.
+	maxLifetime  time.Duration  // Longer runs are restarted, see WithMaxLifetime.
+	requires     []string       // Names of services started first, see WithRequires.
+	wants        []string       // Names of services preferably started first, see WithWants.
+	provides     []string       // Names other services may require, see WithProvides.
+	preStart     []PreStartCheck
+	verify       HealthCheck   // Checked after starting, before the service is ready.
+	sup          *Supervisor   // Set by Add.
+	tasks        chan struct{} // Semaphore limiting tasks started by Go.
+
+	mu          sync.Mutex // Protects fields below.
+	ctx         context.Context
+	cancel      context.CancelFunc
+	state       State
+	reason      StopReason
+	err         error
+	since       time.Time
+	outstanding int           // In-flight work from Track or NewRequestContext.
+	idle        chan struct{} // Closed when outstanding drops to zero.
+	taskErr     error         // First failure of a task started by Go.
+	ready       chan struct{} // Closed once the current run is ready.
+	onReady     func()        // Notifies the supervisor the current run is ready, see launch.
+	done        chan struct{} // Closed once the current run has exited.
+	runs        int           // Number of times Start has been called.
+	shard       *Shard        // Shard assigned to this replica, if any.
+	upSince     time.Time     // When the current run became ready, zero if not running.
+	recycleAt   time.Time     // When the current run reaches its maximum lifetime.
+	uptime      time.Duration // Time spent running by previous runs.
+	lastUptime  time.Duration // Time spent running by the last run.
+	failures    int           // Runs which failed.
+	lastFailure time.Time
+	abandoned   int          // Times the supervisor gave up waiting for this service to stop.
+	outer       []Middleware // Supervisor middleware, wrapping ours.
+	wanted      bool         // EnsureRunning was called, for lazy services.
+	idled       bool         // Stopped for being idle, until wanted again.
+	active      time.Time    // Last activity, see Touch.
+	// Operator restarts, see RestartBy.
+	operatorRestarts int
+	lastAudit        *Audit
+	handoff          []byte // From LoadState, for the Runner of the next run, see Handoff.
+}
+
+// serviceKey is the context key for the Service running a Runner.
+type serviceKey struct{}
+
+// FromContext returns the Service a Runner was started by, from its context, or nil.
+func FromContext(ctx context.Context) *Service {
+	svc, _ := ctx.Value(serviceKey{}).(*Service)
+	return svc
+}
+
+// generationKey is the context key for the generation of a run.
+type generationKey struct{}
+
+// GenerationFromContext returns the generation of the running service, from its context, that
+// is 1 for its first run and incremented by each restart, or 0 outside of a service.
+func GenerationFromContext(ctx context.Context) int {
+	gen, _ := ctx.Value(generationKey{}).(int)
+	return gen
+}
+
+// New creates a new Service, configured by opts.  A Runner must be provided with WithRunner.
+func New(name string, opts ...Option) *Service {
+	s := &Service{name: name, state: StateIdle, since: time.Now()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxTasks > 0 {
+		s.tasks = make(chan struct{}, s.maxTasks)
+	}
+	return s
+}
+
+// withRunner returns a new Service configured like s, but performing its work with r, or with
+// runners created by the factory of s if r is nil.
+func (s *Service) withRunner(r Runner) *Service {
+	var factory func() (Runner, error)
+	if r == nil {
+		factory = s.factory
+	}
+	c := &Service{
+		name:         s.name,
+		runner:       r,
+		factory:      factory,
+		startTimeout: s.startTimeout,
+		stopTimeout:  s.stopTimeout,
+		policy:       s.policy,
+		logger:       s.logger,
+		maxTasks:     s.maxTasks,
+		group:        s.group,
+		replica:      s.replica,
+		shards:       s.shards,
+		optional:     s.optional,
+		checks:       s.checks,
+		liveness:     s.liveness,
+		readiness:    s.readiness,
+		stopRank:     s.stopRank,
+		backoff:      s.backoff,
+		bucket:       s.bucket,
+		unbudgeted:   s.unbudgeted,
+		decider:      s.decider,
+		middleware:   s.middleware,
+		enabled:      s.enabled,
+		lazy:         s.lazy,
+		idleTimeout:  s.idleTimeout,
+		minUptime:    s.minUptime,
+		maxLifetime:  s.maxLifetime,
+		requires:     s.requires,
+		wants:        s.wants,
+		provides:     s.provides,
+		preStart:     s.preStart,
+		verify:       s.verify,
+		state:        StateIdle,
+		since:        time.Now(),
+	}
+	s.mu.Lock()
+	c.shard = s.shard
+	s.mu.Unlock()
+	if c.maxTasks > 0 {
+		c.tasks = make(chan struct{}, c.maxTasks)
+	}
+	return c
+}
+
+// Name returns the name of this service.
+func (s *Service) Name() string {
+	return s.name
+}
+
+// Start runs our Runner in a new goroutine with a child of ctx, returning an error channel which
+// will be closed once this service has exited and all in-flight work is done.  Canceling ctx
+// stops the service, as does Stop.  A panic of the Runner fails the service with a PanicError.
+// The channel is buffered, so the service will exit even if it is never read; the error remains
+// available from Err.  Start is not thread safe, do not call from multiple goroutines.
+// Goroutines of the service carry a "service" profiler label.
+func (s *Service) Start(ctx context.Context) <-chan error {
+	errc := make(chan error, 1)
+	s.launch(ctx, nil, func(err error) {
+		if err != nil {
+			errc <- err
+		}
+		close(errc)
+	})
+	return errc
+}
+
+// launch starts the service as for Start, calling ready once the run is ready, if not nil, and
+// exited with its final error, nil if it exited cleanly, from the goroutine of the run.
+func (s *Service) launch(ctx context.Context, ready func(), exited func(error)) {
+	s.mu.Lock()
+	s.runs++
+	ctx = context.WithValue(ctx, serviceKey{}, s)
+	ctx = context.WithValue(ctx, generationKey{}, s.runs)
+	if s.shard != nil {
+		ctx = context.WithValue(ctx, shardKey{}, *s.shard)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.ctx, s.cancel = ctx, cancel
+	s.ready, s.done = make(chan struct{}), make(chan struct{})
+	s.setState(StateStarting)
+	s.reason, s.err, s.taskErr = ReasonNone, nil, nil
+	s.onReady = ready
+	s.mu.Unlock()
+	go pprof.Do(ctx, pprof.Labels(serviceLabel, s.name), func(ctx context.Context) {
+		err := s.call(ctx, s.run)
+		// Wait for in-flight work, the supervisor stop timeout bounds this.
+		s.Drain(context.Background())
+		s.mu.Lock()
+		if err == nil {
+			err = s.taskErr
+		}
+		s.mu.Unlock()
+		exited(s.exit(err))
+	})
+}
+
+// run calls Start, if implemented, and then Run on our Runner, first creating it if the service
+// has a factory.
+func (s *Service) run(ctx context.Context) error {
+	if s.factory != nil {
+		r, err := s.prepare()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.runner, s.next = r, nil
+		s.mu.Unlock()
+	}
+	runner := s.instance()
+	if runner == nil {
+		return errNoRunner
+	}
+	if err := s.resume(runner); err != nil {
+		return fmt.Errorf("resume: %w", err)
+	}
+	if s.sup != nil && s.sup.chaos != nil {
+		s.sup.chaos.delayStart(ctx, s)
+		if ctx.Err() != nil {
+			return nil
+		}
+	}
+	if st, ok := runner.(Starter); ok {
+		sctx, cancel := ctx, context.CancelFunc(func() {})
+		if s.startTimeout > 0 {
+			sctx, cancel = context.WithTimeoutCause(ctx, s.startTimeout, ErrStartTimeout)
+		}
+		err := st.Start(sctx)
+		if err != nil && sctx.Err() != nil && ctx.Err() == nil {
+			err = context.Cause(sctx)
+		}
+		cancel()
+		if ctx.Err() != nil {
+			// Stop requested while starting.  Once started the runner is still run, with ctx
+			// done, to release what Start acquired, e.g. a listener.
+			if err == nil {
+				runner.Run(ctx)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("start: %w", err)
+		}
+	}
+	s.mu.Lock()
+	r := chain(chain(runner, s.middleware), s.outer)
+	s.mu.Unlock()
+	if s.verify != nil {
+		return s.runVerified(ctx, r)
+	}
+	s.markReady()
+	return r.Run(ctx)
+}
+
+// resume hands any state from LoadState to runner, if it implements Handoff.
+func (s *Service) resume(runner Runner) error {
+	s.mu.Lock()
+	state := s.handoff
+	s.handoff = nil
+	s.mu.Unlock()
+	if h, ok := runner.(Handoff); ok && state != nil {
+		return h.ResumeState(state)
+	}
+	return nil
+}
+
+// generation returns the generation of the latest run of the service.
+func (s *Service) generation() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runs
+}
+
+// prepare returns the Runner for the next run of the service.  If the service has a factory the
+// Runner is created ahead of the run, once, so it can be validated and checked before starting.
+func (s *Service) prepare() (Runner, error) {
+	if s.factory == nil {
+		return s.instance(), nil
+	}
+	s.mu.Lock()
+	r := s.next
+	s.mu.Unlock()
+	if r != nil {
+		return r, nil
+	}
+	r, err := s.factory()
+	if err != nil {
+		return nil, fmt.Errorf("factory: %w", err)
+	}
+	s.mu.Lock()
+	s.next = r
+	s.mu.Unlock()
+	return r, nil
+}
+
+// instance returns the Runner of the service, the one created for its latest run if it has a
+// factory, or nil if it has not yet run.
+func (s *Service) instance() Runner {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.runner
+}
+
+// markReady marks the current run of the service as running and ready, unless it is already
+// stopping, and notifies the supervisor.
+func (s *Service) markReady() {
+	s.mu.Lock()
+	ready := s.state == StateStarting
+	if ready {
+		s.setState(StateRunning)
+		s.upSince = s.since
+		if s.maxLifetime > 0 {
+			// Spread out the recycling of replicas started together.
+			s.recycleAt = s.upSince.Add(s.maxLifetime - rand.N(s.maxLifetime/lifetimeJitter+1))
+		}
+		close(s.ready)
+	}
+	notify := s.onReady
+	s.mu.Unlock()
+	if ready && notify != nil {
+		notify()
+	}
+}
+
+// expired reports whether the running service has reached its maximum lifetime at now.
+func (s *Service) expired(now time.Time) bool {
+	if s.maxLifetime <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state == StateRunning && !now.Before(s.recycleAt)
+}
+
+// Stop requests our service to shutdown.
+func (s *Service) Stop() {
+	s.StopWithReason(ReasonOperator)
+}
+
+// StopWithReason requests our service to shutdown, recording reason as the cause.  Only the
+// first reason is kept if Stop is called repeatedly.
+func (s *Service) StopWithReason(reason StopReason) {
+	s.mu.Lock()
+	if s.state == StateStarting || s.state == StateRunning {
+		s.reason = reason
+		s.setState(StateStopping)
+	}
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// Ready returns a channel closed once the current run of the service is ready, that is its
+// Starter, if any, has returned successfully.  The channel is never closed if the service exits
+// first, so callers should also watch for exit.
+func (s *Service) Ready() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ready == nil {
+		// Never started.
+		return make(chan struct{})
+	}
+	return s.ready
+}
+
+// exited returns a channel closed once the current run of the service has exited.
+func (s *Service) exited() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done == nil {
+		// Never started.
+		return make(chan struct{})
+	}
+	return s.done
+}
+
+// Err returns the final error of the last run once this service has exited, nil if it is still
+// running or exited cleanly.
+func (s *Service) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Snapshot returns the current state of this service.
+func (s *Service) Snapshot() ServiceSnapshot {
+	s.mu.Lock()
+	snap := ServiceSnapshot{
+		Name:   s.name,
+		State:  s.state,
+		Reason: s.reason,
+		Err:    s.err,
+		Since:  s.since,
+		Run:    s.runs,
+	}
+	s.mu.Unlock()
+	if rr, ok := s.instance().(ResourceReporter); ok {
+		stats := rr.ResourceStats()
+		snap.Resources = &stats
+	}
+	return snap
+}
+
+// exit records the outcome of run, wrapping err in an ExitError.
+func (s *Service) exit(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil && s.reason == ReasonNone && s.minUptime > 0 {
+		var up time.Duration
+		if !s.upSince.IsZero() {
+			up = time.Since(s.upSince)
+		}
+		if up < s.minUptime {
+			err = fmt.Errorf("%w %v, after %v", ErrMinUptime, s.minUptime, up.Round(time.Millisecond))
+		}
+	}
+	if s.reason == ReasonNone {
+		s.reason = ReasonExited
+		if err != nil {
+			s.reason = ReasonFailed
+		}
+	}
+	s.setState(StateStopped)
+	if s.reason == ReasonMaintenance || s.reason == ReasonGate {
+		s.setState(StatePaused)
+	}
+	if s.reason == ReasonDisabled {
+		s.setState(StateDisabled)
+	}
+	if s.reason == ReasonIdle {
+		s.setState(StateIdle)
+	}
+	s.lastUptime = 0
+	if !s.upSince.IsZero() {
+		s.lastUptime = s.since.Sub(s.upSince)
+		s.uptime += s.lastUptime
+		s.upSince = time.Time{}
+	}
+	if err != nil {
+		s.setState(StateFailed)
+		s.failures++
+		s.lastFailure = s.since
+		err = &ExitError{Name: s.name, Reason: s.reason, Err: err}
+	}
+	s.err = err
+	close(s.done)
+	return err
+}
+
+// isEnabled reports whether the service should run, see WithEnabled.
+func (s *Service) isEnabled() bool {
+	return s.enabled == nil || s.enabled()
+}
+
+// disable records that the service is not running because it is disabled.
+func (s *Service) disable() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reason = ReasonDisabled
+	s.setState(StateDisabled)
+}
+
+// setState must be called with mu held.
+func (s *Service) setState(state State) {
+	s.state = state
+	s.since = time.Now()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Shard is the portion of work assigned to a replica, see WithShards.
+type Shard struct {
+	Index int // Shard number.
+	Start int // First key owned by the shard, if assigned by range.
+	End   int // Key following the last owned by the shard.
+}
+
+// ShardFunc returns the shard for a replica with index replica, out of replicas.
+type ShardFunc func(replica, replicas int) Shard
+
+// EvenShards returns a ShardFunc splitting keys [0, n) evenly across the replicas.
+func EvenShards(n int) ShardFunc {
+	return func(replica, replicas int) Shard {
+		return Shard{
+			Index: replica,
+			Start: n * replica / replicas,
+			End:   n * (replica + 1) / replicas,
+		}
+	}
+}
+
+// shardKey is the context key for the Shard of a replica.
+type shardKey struct{}
+
+// ShardFromContext returns the shard assigned to the running replica, from its context.
+func ShardFromContext(ctx context.Context) (Shard, bool) {
+	shard, ok := ctx.Value(shardKey{}).(Shard)
+	return shard, ok
+}
+
+// Reshard reassigns the shards of the replicas of the named service with f, restarting only the
+// replicas whose shard changed.
+func (s *Supervisor) Reshard(name string, f ShardFunc) error {
+	var replicas []*Service
+	for _, svc := range s.list() {
+		if svc.Group() == name {
+			replicas = append(replicas, svc)
+		}
+	}
+	if len(replicas) == 0 {
+		return fmt.Errorf("service %q not found", name)
+	}
+	var errs []error
+	for _, svc := range replicas {
+		shard := f(svc.Replica(), len(replicas))
+		svc.mu.Lock()
+		changed := svc.shard == nil || *svc.shard != shard
+		svc.shard = &shard
+		svc.mu.Unlock()
+		if changed {
+			if err := s.Restart(svc.Name(), ReasonReshard); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// stopAll stops svcs, in shutdown order, with up to parallel stopping at once.  Each is stopped
+// only once those of a lower shutdown rank, and those requiring or wanting it, have exited.
+// Services not exiting within their stop timeout, or when shutdown is aborted, are abandoned.
+func (r *runState) stopAll(svcs []*Service, reason StopReason, parallel int) {
+	parallel = max(parallel, 1)
+	pending := r.sup.shutdownOrder(svcs)
+	deadlines := make(map[*Service]time.Time) // Zero without a stop timeout.
+	var stopping []*Service
+	progress := time.NewTicker(shutdownProgress)
+	defer progress.Stop()
+	stop := func(i int) {
+		svc := pending[i]
+		pending = slices.Delete(pending, i, i+1)
+		svc.StopWithReason(reason)
+		if r.running[svc] {
+			stopping = append(stopping, svc)
+			if svc.stopTimeout > 0 {
+				deadlines[svc] = time.Now().Add(svc.stopTimeout)
+			}
+		}
+	}
+	for len(pending) > 0 || len(stopping) > 0 {
+		for i := 0; i < len(pending) && len(stopping) < parallel; {
+			if r.stopBlocked(pending[i], pending, stopping) {
+				i++
+				continue
+			}
+			stop(i)
+		}
+		if len(stopping) == 0 {
+			if len(pending) > 0 {
+				// Every service left blocks another, e.g. services wanting each other, so fall
+				// back to shutdown order.
+				stop(0)
+			}
+			continue
+		}
+		var timeout <-chan time.Time
+		var t *time.Timer
+		if next := r.nextDeadline(stopping, deadlines); !next.IsZero() {
+			t = time.NewTimer(time.Until(next))
+			timeout = t.C
+		}
+		select {
+		case x := <-r.postc:
+			if x.ready {
+				// Shutting down, blocked services are no longer started.
+				r.booted(x.svc)
+			} else {
+				r.exited(x)
+			}
+		case <-progress.C:
+			r.progress(stopping[0])
+		case <-timeout:
+			now := time.Now()
+			for _, svc := range stopping {
+				if d := deadlines[svc]; !d.IsZero() && !now.Before(d) && r.running[svc] {
+					r.sup.loggerFor(svc).Error("service did not stop in time, abandoning it",
+						"service", svc.Name(), "timeout", svc.stopTimeout)
+					r.abandon(svc, ErrStopTimeout)
+				}
+			}
+		case <-r.sup.abortc:
+			for _, svc := range stopping {
+				if r.running[svc] {
+					r.sup.loggerFor(svc).Error("shutdown aborted, abandoning service",
+						"service", svc.Name())
+					r.abandon(svc, ErrAborted)
+				}
+			}
+		}
+		if t != nil {
+			t.Stop()
+		}
+		stopping = slices.DeleteFunc(stopping, func(svc *Service) bool { return !r.running[svc] })
+	}
+}
+
+// stopBlocked reports whether svc must wait for other running services yet to stop, or still
+// stopping: those of a lower shutdown rank, or those requiring or wanting svc.
+func (r *runState) stopBlocked(svc *Service, pending, stopping []*Service) bool {
+	for _, other := range slices.Concat(pending, stopping) {
+		if other == svc || !r.running[other] {
+			continue
+		}
+		if other.stopRank < svc.stopRank {
+			return true
+		}
+		deps, _ := r.sup.requirements(other)
+		if slices.Contains(deps, svc) || slices.Contains(r.sup.soft(other), svc) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextDeadline returns the earliest stop deadline of the stopping services, or zero if none
+// have one.
+func (r *runState) nextDeadline(stopping []*Service, deadlines map[*Service]time.Time) time.Time {
+	var next time.Time
+	for _, svc := range stopping {
+		if d := deadlines[svc]; !d.IsZero() && (next.IsZero() || d.Before(next)) {
+			next = d
+		}
+	}
+	return next
+}
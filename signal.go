@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Exit codes of Main for each outcome of Run, see ExitCode.
+const (
+	ExitClean       = 0 // All services stopped without error.
+	ExitFailed      = 1 // A service failed, e.g. after exhausting the restart budget.
+	ExitStopTimeout = 2 // A service was abandoned after its stop timeout, or abort.
+	ExitForced      = 3 // Repeated signals forced the process to exit.
+	exitSignal      = 128
+)
+
+// ExitCode is the default mapping of the error returned by Run, with the context passed to it,
+// to a process exit code: ExitClean, ExitFailed, ExitStopTimeout, or 128 plus the signal number
+// if shutdown was caused by a signal, e.g. 130 for SIGINT or 143 for SIGTERM, following shell
+// conventions.  Errors take precedence over signals.
+func ExitCode(ctx context.Context, err error) int {
+	switch {
+	case errors.Is(err, ErrStopTimeout) || errors.Is(err, ErrAborted):
+		return ExitStopTimeout
+	case err != nil:
+		return ExitFailed
+	}
+	var sigErr *SignalError
+	if errors.As(context.Cause(ctx), &sigErr) {
+		if sig, ok := sigErr.Signal.(syscall.Signal); ok {
+			return exitSignal + int(sig)
+		}
+	}
+	return ExitClean
+}
+
+// HandleSignals escalates shutdown as sigs are received.  The first signal cancels the context
+// passed to Run, with a SignalError cause, for a graceful stop.  A second signal, or escalate
+// elapsing after the first if it is not zero, aborts the shutdown, abandoning services that have
+// yet to stop.  A third signal exits the process immediately with ExitForced.
+func (s *Supervisor) HandleSignals(cancel context.CancelCauseFunc, escalate time.Duration,
+	sigs ...os.Signal) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, sigs...)
+	go func() {
+		sig := <-sigc
+		s.logger.Info("received signal, stopping gracefully", "signal", sig)
+		cancel(&SignalError{Signal: sig})
+		var timeout <-chan time.Time
+		if escalate > 0 {
+			timeout = time.After(escalate)
+		}
+		select {
+		case sig = <-sigc:
+			s.logger.Warn("received second signal, aborting shutdown", "signal", sig)
+		case <-timeout:
+			s.logger.Warn("shutdown timed out, aborting", "timeout", escalate)
+		}
+		s.Abort()
+		sig = <-sigc
+		s.logger.Error("received third signal, exiting", "signal", sig)
+		os.Exit(ExitForced)
+	}()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Handoff may be implemented by a Runner to carry state over to its successor in a new process,
+// e.g. a stream cursor, see SaveState.
+type Handoff interface {
+	// HandoffState returns the state to hand over, called by SaveState.
+	HandoffState() ([]byte, error)
+	// ResumeState restores state handed over by a predecessor, called before the first run
+	// after LoadState.  An error fails that run.
+	ResumeState(state []byte) error
+}
+
+// supervisorState is the restart state of a supervisor persisted by WithStateFile, so that crash
+// loop detection survives restarts of the process itself, and handed over by SaveState.
+type supervisorState struct {
+	Budget   *int                    `json:"budget,omitempty"` // Remaining restart budget.
+	Services map[string]serviceState `json:"services,omitempty"`
+}
+
+// serviceState is the restart state of a service, see supervisorState.
+type serviceState struct {
+	Failures []time.Time `json:"failures,omitempty"` // Counted by the restart policy.
+	Panics   []time.Time `json:"panics,omitempty"`
+	Restarts []time.Time `json:"restarts,omitempty"` // Recent restarts, for CrashLoop.
+	Attempts int         `json:"attempts,omitempty"` // Consecutive failures, for Backoff.
+	Breaker  bool        `json:"breaker,omitempty"`  // The restart policy breaker is open.
+	Handoff  []byte      `json:"handoff,omitempty"`  // From the Runner, see Handoff.
+}
+
+// SaveState writes the restart state of the supervisor to w, as of its latest change, along with
+// the state of Runners implementing Handoff, for LoadState in another process.  Upgrade hands
+// over state this way.
+func (s *Supervisor) SaveState(w io.Writer) error {
+	s.stateMu.Lock()
+	state := s.lastState
+	s.stateMu.Unlock()
+	state.Services = maps.Clone(state.Services)
+	if state.Services == nil {
+		state.Services = make(map[string]serviceState)
+	}
+	var errs []error
+	for _, svc := range s.list() {
+		h, ok := svc.instance().(Handoff)
+		if !ok {
+			continue
+		}
+		b, err := h.HandoffState()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %q: %w", svc.Name(), err))
+			continue
+		}
+		ss := state.Services[svc.Name()]
+		ss.Handoff = b
+		state.Services[svc.Name()] = ss
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("handoff: %w", err)
+	}
+	return json.NewEncoder(w).Encode(state)
+}
+
+// LoadState reads state written by SaveState from r, to be restored by the next Run in place of
+// any state file, see WithStateFile.
+func (s *Supervisor) LoadState(r io.Reader) error {
+	var state supervisorState
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	s.stateMu.Lock()
+	s.restored = &state
+	s.stateMu.Unlock()
+	return nil
+}
+
+// state returns the restart state of the run.
+func (r *runState) state() supervisorState {
+	state := supervisorState{Services: make(map[string]serviceState)}
+	if r.sup.budgeted {
+		state.Budget = new(int)
+		*state.Budget = r.sup.restarts
+	}
+	for _, svc := range r.sup.list() {
+		ss := serviceState{
+			Failures: slices.Clone(r.failures[svc]),
+			Panics:   slices.Clone(r.panics[svc]),
+			Restarts: slices.Clone(r.restarts[svc]),
+			Attempts: r.attempts[svc],
+			Breaker:  r.breakers[svc],
+		}
+		if len(ss.Failures) > 0 || len(ss.Restarts) > 0 || ss.Attempts > 0 || ss.Breaker {
+			state.Services[svc.Name()] = ss
+		}
+	}
+	return state
+}
+
+// restore restores the restart state of the run from state, for services of the same name.
+func (r *runState) restore(state supervisorState) {
+	if r.sup.budgeted && state.Budget != nil && *state.Budget < r.sup.restarts {
+		r.sup.mu.Lock()
+		r.sup.restarts = *state.Budget
+		r.sup.mu.Unlock()
+	}
+	for _, svc := range r.sup.list() {
+		ss, ok := state.Services[svc.Name()]
+		if !ok {
+			continue
+		}
+		r.failures[svc], r.panics[svc], r.restarts[svc] = ss.Failures, ss.Panics, ss.Restarts
+		if ss.Attempts > 0 {
+			r.attempts[svc] = ss.Attempts
+		}
+		if ss.Breaker {
+			r.breakers[svc] = true
+		}
+		if ss.Handoff != nil {
+			svc.mu.Lock()
+			svc.handoff = ss.Handoff
+			svc.mu.Unlock()
+		}
+	}
+}
+
+// tripped reports whether the restart policy breaker of svc was open when its state was
+// restored, and its failures are still within the policy Window at now.
+func (r *runState) tripped(svc *Service, now time.Time) bool {
+	p := r.sup.policyFor(svc)
+	if !r.breakers[svc] || p == nil {
+		return false
+	}
+	return len(within(r.failures[svc], now, p.Window)) > p.MaxRestarts ||
+		p.MaxPanics > 0 && len(within(r.panics[svc], now, p.Window)) > p.MaxPanics
+}
+
+// loadState restores the restart state of the run from LoadState, or otherwise the state file if
+// there is one.  A missing file is not an error.
+func (r *runState) loadState() {
+	defer r.saveState()
+	r.sup.stateMu.Lock()
+	restored := r.sup.restored
+	r.sup.restored = nil
+	r.sup.stateMu.Unlock()
+	if restored != nil {
+		r.restore(*restored)
+		return
+	}
+	path := r.sup.stateFile
+	if path == "" {
+		return
+	}
+	var state supervisorState
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err == nil {
+		err = json.Unmarshal(b, &state)
+	}
+	if err != nil {
+		r.sup.logger.Error("failed to load state file, starting afresh", "path", path, "err", err)
+		return
+	}
+	r.restore(state)
+	r.sup.logger.Info("restored restart state", "path", path, "services", len(state.Services))
+}
+
+// saveState records the restart state of the run for SaveState, and writes it to the state file
+// if there is one, replacing it atomically so it is never left partly written.
+func (r *runState) saveState() {
+	state := r.state()
+	r.sup.stateMu.Lock()
+	r.sup.lastState = state
+	r.sup.stateMu.Unlock()
+	path := r.sup.stateFile
+	if path == "" {
+		return
+	}
+	err := writeFileAtomic(path, func(f *os.File) error {
+		return json.NewEncoder(f).Encode(state)
+	})
+	if err != nil {
+		r.sup.logger.Error("failed to save state file", "path", path, "err", err)
+	}
+}
+
+// writeFileAtomic writes path with write, via a temporary file renamed over it.
+func writeFileAtomic(path string, write func(*os.File) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package main
+
+import "time"
+
+// ServiceStats are reliability figures for a service, accumulated over all of its runs, for
+// capacity and reliability reviews.
+type ServiceStats struct {
+	Name         string        `json:"name"`
+	Runs         int           `json:"runs"` // Generation of the latest run.
+	Failures     int           `json:"failures"`
+	Uptime       time.Duration `json:"uptime"`                  // Total time spent running.
+	LastFailure  time.Time     `json:"last_failure,omitzero"`   // Zero if it has never failed.
+	SinceFailure time.Duration `json:"since_failure,omitempty"` // Zero if it has never failed.
+	MTBF         time.Duration `json:"mtbf,omitempty"`          // Mean uptime between failures.
+	Abandoned    int           `json:"abandoned,omitempty"`     // Times abandoned at shutdown.
+	// Restarts requested by operators, rather than after failures, see RestartBy.
+	OperatorRestarts int    `json:"operator_restarts,omitempty"`
+	LastOperator     *Audit `json:"last_operator,omitempty"`
+}
+
+// Stats returns the reliability figures of this service.
+func (s *Service) Stats() ServiceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	stats := ServiceStats{
+		Name:             s.name,
+		Runs:             s.runs,
+		Failures:         s.failures,
+		Uptime:           s.uptime,
+		LastFailure:      s.lastFailure,
+		Abandoned:        s.abandoned,
+		OperatorRestarts: s.operatorRestarts,
+		LastOperator:     s.lastAudit,
+	}
+	if !s.upSince.IsZero() {
+		stats.Uptime += now.Sub(s.upSince)
+	}
+	if s.failures > 0 {
+		stats.SinceFailure = now.Sub(s.lastFailure)
+		stats.MTBF = stats.Uptime / time.Duration(s.failures)
+	}
+	return stats
+}
+
+// Stats returns the reliability figures of all services.
+func (s *Supervisor) Stats() []ServiceStats {
+	svcs := s.list()
+	stats := make([]ServiceStats, 0, len(svcs))
+	for _, svc := range svcs {
+		stats = append(stats, svc.Stats())
+	}
+	return stats
+}
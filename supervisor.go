@@ -0,0 +1,1029 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// eventBuffer is the number of events held for a slow Events() reader before dropping.
+const eventBuffer = 100
+
+// idleInterval is how often services are checked against their idle timeout and maximum
+// lifetime.
+const idleInterval = time.Second
+
+// shutdownProgress is how often progress is reported while waiting for services to stop.
+const shutdownProgress = time.Second * 5
+
+var (
+	// ErrRestartsExhausted is returned by Run when a failure occurs after the restart budget has
+	// been used up.
+	ErrRestartsExhausted = errors.New("restart budget exhausted")
+	// ErrDeadline is the cancel cause used when the supervisor reaches its scheduled end.
+	ErrDeadline = errors.New("supervisor deadline reached")
+	// ErrStartTimeout is the failure of a service whose Starter exceeds its start timeout.
+	ErrStartTimeout = errors.New("start timed out")
+	// ErrStopTimeout is the final error of a service that did not exit within its stop timeout.
+	ErrStopTimeout = errors.New("stop timed out")
+	// ErrAborted is the final error of a service still stopping when shutdown was aborted.
+	ErrAborted = errors.New("shutdown aborted")
+	// ErrNotRunning is returned by RestartBy for a service that is not running, or is paused.
+	ErrNotRunning = errors.New("not running")
+)
+
+// SignalError should be used as the cancel cause of the context passed to Run when shutting
+// down due to a signal, so services record ReasonSignal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("received signal %v", e.Signal)
+}
+
+// Supervisor starts a set of services, and restarts them after failures.
+type Supervisor struct {
+	mu            sync.Mutex // Protects services, which Swap may modify during Run, and restarts.
+	services      []*Service
+	budgeted      bool // Limit restarts to the shared budget in restarts.
+	restarts      int
+	duration      time.Duration
+	deadline      time.Time
+	logger        *slog.Logger // Logger for the supervisor core, see SetLogLevel.
+	baseLogger    *slog.Logger // Default logger for services.
+	levelMu       sync.Mutex   // Protects levels and loggers.
+	levels        map[string]*componentLevel
+	loggers       map[*Service]*slog.Logger
+	banner        bool
+	pidFile       string
+	instanceLock  string
+	liveness      time.Duration // Interval between liveness probes, zero for none.
+	windows       map[*Service][]Window
+	gates         map[*Service]Gate
+	events        chan Event
+	failures      chan ServiceError
+	dropped       atomic.Int64 // Events and failures dropped, see Dropped.
+	restartc      chan restart
+	upgradec      chan upgrade
+	swapc         chan *swap
+	abortc        chan struct{}              // Closed by Abort.
+	reconcilec    chan struct{}              // Signals Reconcile.
+	abandoned     []Abandoned                // Protected by mu.
+	hooks         []func(Event)              // Called with every event, see WithEventHook.
+	adminIdentity func(*http.Request) string // See WithAdminIdentity.
+	adminClients  []AdminClient              // See WithAdminClients.
+	adminBurst    int                        // See WithAdminRateLimit.
+	adminRefill   time.Duration
+	adminMu       sync.Mutex                // Protects adminBuckets.
+	adminBuckets  map[string]*RestartBucket // Rate limits of admin requesters.
+	middleware    []Middleware
+	progress      func(done, total int, current string) // See WithProgress.
+	chaos         *Chaos                                // See WithChaos.
+	stopParallel  int                                   // See WithStopParallelism.
+	draining      atomic.Bool                           // Shutdown has begun, see Draining.
+	drainDelay    time.Duration                         // See WithDrainDelay.
+	drainProbes   []string                              // Admin endpoints failed while draining.
+	debounce      time.Duration                         // See WithRestartDebounce.
+	crashHooks    []func(CrashLoop)
+	exitHooks     []func(error)                    // See WithExitHook.
+	exitCode      func(context.Context, error) int // See WithExitCodes.
+	decider       FailureDecider                   // See WithFailureDecider.
+	stateFile     string                           // See WithStateFile.
+	stateMu       sync.Mutex                       // Protects lastState and restored.
+	lastState     supervisorState                  // As of its latest change, for SaveState.
+	restored      *supervisorState                 // From LoadState, for the next Run.
+	policy        *RestartPolicy                   // See WithDefaultRestartPolicy.
+	backoff       Backoff                          // See WithDefaultBackoff.
+	recentMu      sync.Mutex                       // Protects recent.
+	recent        []Event                          // Most recent events, for CrashLoop.
+	abortOnce     sync.Once
+}
+
+// post is posted by a run of a service to the supervision loop once it is ready, and again with
+// its final error once it has exited.
+type post struct {
+	svc   *Service
+	ready bool
+	err   error
+}
+
+// restart is a request to gracefully restart a running service.
+type restart struct {
+	svc    *Service
+	reason StopReason
+	audit  *Audit     // Set for operator restarts, see RestartBy.
+	errc   chan error // Receives whether the restart was accepted, if not nil.
+}
+
+// NewSupervisor creates a Supervisor configured by opts.
+func NewSupervisor(opts ...SupervisorOption) *Supervisor {
+	s := &Supervisor{
+		events:       make(chan Event, eventBuffer),
+		failures:     make(chan ServiceError, eventBuffer),
+		restartc:     make(chan restart, eventBuffer),
+		upgradec:     make(chan upgrade),
+		swapc:        make(chan *swap),
+		abortc:       make(chan struct{}),
+		reconcilec:   make(chan struct{}, 1),
+		adminBuckets: make(map[string]*RestartBucket),
+	}
+	s.Apply(WithSupervisorLogger(slog.Default()))
+	s.Apply(opts...)
+	return s
+}
+
+// Apply configures the supervisor with further opts, e.g. from the build function passed to
+// Main.  Apply must not be called once Run has been called.
+func (s *Supervisor) Apply(opts ...SupervisorOption) {
+	logger := s.logger
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.logger != logger {
+		// Replaced by WithSupervisorLogger.
+		s.baseLogger = s.logger
+		if s.logger != nil {
+			s.logger = s.leveled(SupervisorComponent, s.logger)
+		}
+	}
+}
+
+// Add registers services to be started by Run, in order.  Services created WithReplicas are
+// expanded into their replicas.  Add must not be called once Run has been called.
+func (s *Supervisor) Add(svcs ...*Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, svc := range svcs {
+		for _, r := range replicate(svc) {
+			r.sup = s
+			s.services = append(s.services, r)
+		}
+	}
+}
+
+// Events returns a channel of the most recent service lifecycle events.  The oldest events are
+// dropped rather than block the supervisor if the channel is not read, see Dropped.  Event.Typed
+// converts each for a type switch.
+func (s *Supervisor) Events() <-chan Event {
+	return s.events
+}
+
+// ServiceError is the failure of a single run of a service, see Failures.
+type ServiceError struct {
+	Name    string
+	Err     error
+	Attempt int // Consecutive failures of the service.
+}
+
+func (e ServiceError) Error() string {
+	err := e.Err
+	if ee, ok := err.(*ExitError); ok {
+		// Without repeating the service name.
+		err = ee.Err
+	}
+	return fmt.Sprintf("service %s failed, attempt %d: %v", e.Name, e.Attempt, err)
+}
+
+func (e ServiceError) Unwrap() error {
+	return e.Err
+}
+
+// Failures returns a channel receiving the failure of every run of any service, so that
+// consumers can handle all services, including those restarted, with a single receive.  Like
+// Events, the oldest failures are dropped rather than block the supervisor if it is not read.
+func (s *Supervisor) Failures() <-chan ServiceError {
+	return s.failures
+}
+
+// Restart requests the named service be gracefully stopped with reason, and then started again
+// without consuming the restart budget.  Restart does not wait for the service to restart.
+func (s *Supervisor) Restart(name string, reason StopReason) error {
+	svc := s.service(name)
+	if svc == nil {
+		return fmt.Errorf("unknown service %q", name)
+	}
+	select {
+	case s.restartc <- restart{svc: svc, reason: reason}:
+		return nil
+	default:
+		return fmt.Errorf("too many pending restarts, service %q not restarted", name)
+	}
+}
+
+// Reconcile evaluates the WithEnabled functions of services again, starting those newly enabled
+// and stopping those newly disabled, and starts lazy services that are wanted.  Config calls
+// Reconcile after each update.
+func (s *Supervisor) Reconcile() {
+	select {
+	case s.reconcilec <- struct{}{}:
+	default:
+		// Already pending.
+	}
+}
+
+// Abort gives up waiting for services to stop during shutdown, abandoning any still running so
+// that Run returns promptly.  Services not yet stopped are still stopped, but not waited for.
+func (s *Supervisor) Abort() {
+	s.abortOnce.Do(func() {
+		close(s.abortc)
+	})
+}
+
+// Snapshot returns the current state of all services.
+func (s *Supervisor) Snapshot() []ServiceSnapshot {
+	svcs := s.list()
+	snaps := make([]ServiceSnapshot, 0, len(svcs))
+	for _, svc := range svcs {
+		snaps = append(snaps, svc.Snapshot())
+	}
+	return snaps
+}
+
+// Run starts all services, each once those it requires are ready, restarting them after failures
+// until ctx is done, the restart budget is exhausted, or the scheduled deadline is reached.
+// Services are then stopped in reverse order, or by WithShutdownRank, and before those they
+// require, each one exiting or exceeding its stop timeout before the next is stopped, unless
+// WithStopParallelism allows more at once.  The returned error joins the final errors of all
+// services.  Nothing is started if Validate or any pre-start check fails.
+func (s *Supervisor) Run(ctx context.Context) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.instanceLock != "" {
+		f, err := lockInstance(s.instanceLock)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+	}
+	s.draining.Store(false)
+	if err := s.preStart(ctx); err != nil {
+		return err
+	}
+	if s.pidFile != "" {
+		if err := writePIDFile(s.pidFile); err != nil {
+			return err
+		}
+		defer func() {
+			if err := removePIDFile(s.pidFile); err != nil {
+				s.logger.Error("failed to remove pid file", "path", s.pidFile, "err", err)
+			}
+		}()
+	}
+	s.resumeUpgrade()
+	if s.banner {
+		s.logBanner()
+	}
+	deadline := s.deadline
+	if s.duration > 0 {
+		if end := time.Now().Add(s.duration); deadline.IsZero() || end.Before(deadline) {
+			deadline = end
+		}
+	}
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadlineCause(ctx, deadline, ErrDeadline)
+		defer cancel()
+	}
+	// Service contexts are children of sctx, which carries the values but not the cancellation of
+	// ctx, so that shutdown proceeds in order.  sctx is canceled as Run returns, so no service
+	// context outlives the supervisor.
+	sctx, scancel := context.WithCancel(
+		context.WithValue(context.WithoutCancel(ctx), supervisorKey{}, s))
+	defer scancel()
+	r := &runState{
+		sup: s,
+		ctx: sctx,
+		// Buffered so runs never block posting, each has at most a ready and an exit post
+		// outstanding, as might a swap, or a run abandoned after its stop timeout.
+		postc:      make(chan post, 4*len(s.list())),
+		gatec:      make(chan gateChange),
+		delayc:     make(chan *Service),
+		debouncec:  make(chan debounce),
+		swapEvc:    make(chan swapEvent),
+		done:       make(chan struct{}),
+		running:    make(map[*Service]bool),
+		restarting: make(map[*Service]bool),
+		blocked:    make(map[*Service]bool),
+		booting:    make(map[*Service]bool),
+		audits:     make(map[*Service]*Audit),
+		causes:     make(map[*Service][]StopReason),
+		debounced:  make(map[*Service]int),
+		paused:     make(map[*Service]bool),
+		delayed:    make(map[*Service]bool),
+		gateOpen:   make(map[*Service]bool),
+		failures:   make(map[*Service][]time.Time),
+		panics:     make(map[*Service][]time.Time),
+		swaps:      make(map[*Service]*swap),
+		retired:    make(map[*Service]bool),
+		final:      make(map[*Service]error),
+		errors:     make(map[*Service][]error),
+		restarts:   make(map[*Service][]time.Time),
+		attempts:   make(map[*Service]int),
+		breakers:   make(map[*Service]bool),
+	}
+	defer close(r.done)
+	gctx, gcancel := context.WithCancel(ctx)
+	defer gcancel()
+	for svc, gate := range s.gates {
+		go s.watchGate(gctx, svc, gate, r.gatec)
+	}
+	if s.liveness > 0 {
+		go s.probeLiveness(gctx, s.liveness)
+	}
+	if s.chaos != nil {
+		s.logger.Warn("chaos enabled", "seed", s.chaos.Seed())
+		go s.chaos.run(gctx, s)
+	}
+	var windowc <-chan time.Time
+	if len(s.windows) > 0 {
+		ticker := time.NewTicker(windowInterval)
+		defer ticker.Stop()
+		windowc = ticker.C
+	}
+	var idlec <-chan time.Time
+	if slices.ContainsFunc(s.list(), func(svc *Service) bool {
+		return svc.idleTimeout > 0 || svc.maxLifetime > 0
+	}) {
+		ticker := time.NewTicker(idleInterval)
+		defer ticker.Stop()
+		idlec = ticker.C
+	}
+	r.loadState()
+	now := time.Now()
+	for _, svc := range s.list() {
+		if r.tripped(svc, now) {
+			s.loggerFor(svc).Error("restart policy breaker still open, not starting",
+				"service", svc.Name())
+			continue
+		}
+		delete(r.breakers, svc)
+		if s.progress != nil && !r.held(svc, now) {
+			r.booting[svc] = true
+			r.bootTotal++
+		}
+	}
+	for _, svc := range s.startOrder(s.list()) {
+		if r.breakers[svc] {
+			continue
+		}
+		if r.held(svc, now) {
+			r.paused[svc] = true
+			if !svc.isEnabled() {
+				svc.disable()
+			}
+			continue
+		}
+		r.start(svc)
+	}
+	reason := ReasonShutdown
+	var cause error
+	var upgrading *upgrade
+supervise:
+	for len(r.running) > 0 || len(r.paused) > 0 || len(r.delayed) > 0 {
+		select {
+		case x := <-r.postc:
+			if x.ready {
+				r.booted(x.svc)
+				r.unblock()
+				continue
+			}
+			r.exited(x)
+			if r.retired[x.svc] {
+				// Replaced by Swap.
+				delete(r.retired, x.svc)
+				continue
+			}
+			if sw := r.swaps[x.svc]; sw != nil {
+				r.swapFailed(sw, x.err)
+				continue
+			}
+			if r.paused[x.svc] {
+				// Held by a window or gate, resume if that has already ended.
+				r.resume(x.svc)
+				continue
+			}
+			if r.restarting[x.svc] {
+				delete(r.restarting, x.svc)
+				r.start(x.svc)
+				continue
+			}
+			if x.err == nil {
+				// Exited cleanly, nothing to restart.
+				delete(r.attempts, x.svc)
+				r.saveState()
+				continue
+			}
+			logger := s.loggerFor(x.svc)
+			logger.Error("service failed", "service", x.svc.Name(), "err", x.err)
+			r.errors[x.svc] = lastN(append(r.errors[x.svc], x.err), crashLoopHistory)
+			decision, decide := DecideDefault, s.decider
+			if x.svc.decider != nil {
+				decide = x.svc.decider
+			}
+			if decide != nil {
+				decision = decide(x.svc.Name(), x.err, r.attempts[x.svc]+1)
+			}
+			switch decision {
+			case DecideRestart:
+				logger.Info("restarting service by decision", "service", x.svc.Name())
+				r.restartAfter(x.svc)
+				continue
+			case DecideStop:
+				logger.Error("not restarting service by decision", "service", x.svc.Name())
+				r.crashLoop(x.svc)
+				continue
+			}
+			if decision == DecideEscalate || IsFatal(x.err) {
+				logger.Error("fatal service failure, escalating", "service", x.svc.Name())
+				reason, cause = ReasonFatal, ErrFatal
+				break supervise
+			}
+			if !r.allow(x.svc, x.err) {
+				logger.Error("restart policy breaker open, not restarting",
+					"service", x.svc.Name())
+				s.emit(Event{Service: x.svc.Name(), Type: EventBreakerOpen, Err: x.err})
+				r.breakers[x.svc] = true
+				r.saveState()
+				r.crashLoop(x.svc)
+				continue
+			}
+			if x.svc.bucket != nil && !x.svc.bucket.take() {
+				logger.Error("shared restart bucket empty, escalating", "service", x.svc.Name())
+				reason, cause = ReasonRestartPolicy, ErrBucketEmpty
+				break supervise
+			}
+			args := []any{"service", x.svc.Name()}
+			if s.budgeted && !x.svc.unbudgeted {
+				if s.restarts <= 0 {
+					reason, cause = ReasonRestartPolicy, ErrRestartsExhausted
+					break supervise
+				}
+				s.mu.Lock()
+				s.restarts--
+				s.mu.Unlock()
+				args = append(args, "budget", s.restarts)
+			}
+			logger.Info("restarting service", args...)
+			r.restartAfter(x.svc)
+		case svc := <-r.delayc:
+			if r.delayed[svc] {
+				delete(r.delayed, svc)
+				if r.paused[svc] {
+					// Held while waiting, started again by resume.
+					continue
+				}
+				r.start(svc)
+			}
+		case now := <-idlec:
+			for _, svc := range s.list() {
+				if r.running[svc] && !r.paused[svc] && svc.inactive(now) {
+					s.loggerFor(svc).Info("service idle, stopping", "service", svc.Name())
+					r.hold(svc, ReasonIdle)
+				}
+				if r.running[svc] && !r.paused[svc] && !r.restarting[svc] && svc.expired(now) {
+					s.loggerFor(svc).Info("service reached maximum lifetime, recycling",
+						"service", svc.Name())
+					r.restarting[svc] = true
+					svc.StopWithReason(ReasonRecycle)
+				}
+			}
+		case <-s.reconcilec:
+			for _, svc := range s.list() {
+				if svc.isEnabled() {
+					r.resume(svc)
+				} else {
+					r.hold(svc, ReasonDisabled)
+				}
+			}
+		case req := <-s.restartc:
+			r.requestRestart(req)
+		case db := <-r.debouncec:
+			if r.debounced[db.svc] == db.run {
+				delete(r.debounced, db.svc)
+				r.restartNow(db.svc)
+			}
+		case now := <-windowc:
+			for svc, windows := range s.windows {
+				if inWindow(windows, now) {
+					r.hold(svc, ReasonMaintenance)
+				} else {
+					r.resume(svc)
+				}
+			}
+		case gc := <-r.gatec:
+			r.gateOpen[gc.svc] = gc.open
+			if gc.open {
+				r.resume(gc.svc)
+			} else {
+				r.hold(gc.svc, ReasonGate)
+			}
+		case sw := <-s.swapc:
+			r.startSwap(sw)
+		case ev := <-r.swapEvc:
+			r.swapped(ev)
+		case req := <-s.upgradec:
+			reason, upgrading = ReasonUpgrade, &req
+			break supervise
+		case <-ctx.Done():
+			var sigErr *SignalError
+			if cause := context.Cause(ctx); errors.As(cause, &sigErr) {
+				reason = ReasonSignal
+			} else if errors.Is(cause, ErrDeadline) {
+				reason = ReasonDeadline
+			}
+			break supervise
+		}
+	}
+
+	s.logger.Info("shutting down", "reason", reason)
+	r.stopping = time.Now()
+	s.draining.Store(true)
+	if s.drainDelay > 0 {
+		// Give load balancers time to take us out of rotation before services begin draining.
+		s.logger.Info("failing readiness before draining", "delay", s.drainDelay,
+			"endpoints", s.drainProbes)
+		t := time.NewTimer(s.drainDelay)
+		select {
+		case <-t.C:
+		case <-s.abortc:
+			s.logger.Warn("shutdown aborted, draining at once")
+			t.Stop()
+		}
+	}
+	gcancel()
+	for green, sw := range r.swaps {
+		if !sw.ready {
+			green.StopWithReason(reason)
+			r.wait(green)
+		}
+		sw.errc <- errors.New("supervisor shut down during swap")
+	}
+	for blue := range r.retired {
+		r.wait(blue)
+	}
+	svcs := s.list()
+	r.stopAll(svcs, reason, s.stopParallel)
+	elapsed := time.Since(r.stopping)
+	s.logger.Info("services stopped", "elapsed", elapsed.Round(time.Millisecond))
+	for _, gate := range s.gates {
+		if err := gate.Release(); err != nil {
+			s.logger.Error("failed to release gate", "err", err)
+		}
+	}
+
+	if upgrading != nil {
+		r.saveState()
+		// Only returns on failure.
+		err := s.execUpgrade(*upgrading)
+		upgrading.errc <- err
+		return err
+	}
+
+	var errs []error
+	for _, svc := range svcs {
+		if err := r.final[svc]; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	err := errors.Join(errs...)
+	if cause != nil {
+		err = fmt.Errorf("%w: %w", cause, err)
+	}
+	s.emit(Event{Type: EventShutdownComplete, Reason: reason, Err: err, Elapsed: elapsed})
+	for _, hook := range s.exitHooks {
+		hook(err)
+	}
+	return err
+}
+
+// runState tracks services during a single call to Run.
+type runState struct {
+	sup        *Supervisor
+	ctx        context.Context // Parent of all service contexts.
+	postc      chan post       // Posts from the runs of all services.
+	gatec      chan gateChange
+	delayc     chan *Service // Services whose restart delay has elapsed.
+	debouncec  chan debounce // Restart requests whose debounce window has elapsed.
+	swapEvc    chan swapEvent
+	done       chan struct{}     // Closed when Run returns.
+	running    map[*Service]bool // Started, and not yet exited.
+	restarting map[*Service]bool // Stopping, to be started again on exit.
+	blocked    map[*Service]bool // Waiting for the services they require to be ready.
+	booting    map[*Service]bool // Not yet ready since Run began, for WithProgress.
+	bootTotal  int
+	audits     map[*Service]*Audit       // Requesters of pending operator restarts.
+	causes     map[*Service][]StopReason // Reasons of restart requests coalesced, in order.
+	debounced  map[*Service]int          // Run of services waiting out their restart debounce.
+	paused     map[*Service]bool         // Held by a maintenance window or gate.
+	delayed    map[*Service]bool         // Waiting out their restart delay.
+	gateOpen   map[*Service]bool         // Gated services that currently hold their gate.
+	failures   map[*Service][]time.Time  // Recent failures, for the restart policy.
+	panics     map[*Service][]time.Time  // Recent panics, for the restart policy.
+	final      map[*Service]error        // Error from the last exit of each service.
+	swaps      map[*Service]*swap        // Swaps in progress, by green instance.
+	retired    map[*Service]bool         // Blue instances stopping after a swap.
+	stopping   time.Time                 // When shutdown began.
+	errors     map[*Service][]error      // Recent failures, for CrashLoop.
+	restarts   map[*Service][]time.Time  // Recent restarts after failures, for CrashLoop.
+	attempts   map[*Service]int          // Consecutive failures, for Backoff.
+	breakers   map[*Service]bool         // Not restarted, as their restart policy breaker opened.
+}
+
+// debounce is posted once the debounce window of a restart request for run of svc elapses.
+type debounce struct {
+	svc *Service
+	run int
+}
+
+// requestRestart restarts a running service for req, coalescing it with a restart already
+// pending, and waiting out the restart debounce window first if there is one.
+func (r *runState) requestRestart(req restart) {
+	svc := req.svc
+	if !r.running[svc] || r.paused[svc] {
+		if req.errc != nil {
+			req.errc <- fmt.Errorf("service %q %w, not restarted", svc.Name(), ErrNotRunning)
+		}
+		return
+	}
+	if req.errc != nil {
+		req.errc <- nil
+	}
+	if req.audit != nil && r.audits[svc] == nil {
+		r.audits[svc] = req.audit
+	}
+	if _, ok := r.debounced[svc]; ok || r.restarting[svc] {
+		if !slices.Contains(r.causes[svc], req.reason) {
+			r.causes[svc] = append(r.causes[svc], req.reason)
+		}
+		return
+	}
+	r.causes[svc] = []StopReason{req.reason}
+	d := r.sup.debounce
+	if d <= 0 {
+		r.restartNow(svc)
+		return
+	}
+	db := debounce{svc: svc, run: svc.generation()}
+	r.debounced[svc] = db.run
+	time.AfterFunc(d, func() {
+		select {
+		case r.debouncec <- db:
+		case <-r.done:
+		}
+	})
+}
+
+// restartNow stops svc to be restarted, for the first of its coalesced causes.
+func (r *runState) restartNow(svc *Service) {
+	if !r.running[svc] || r.paused[svc] || r.restarting[svc] || len(r.causes[svc]) == 0 {
+		return
+	}
+	r.restarting[svc] = true
+	svc.StopWithReason(r.causes[svc][0])
+}
+
+// start starts svc, posting its readiness and exit to postc.  If the services svc
+// requires are not yet ready, it is blocked until they are.
+func (r *runState) start(svc *Service) {
+	if !r.satisfied(svc) {
+		if !r.blocked[svc] {
+			r.blocked[svc] = true
+			r.sup.loggerFor(svc).Info("waiting for required services", "service", svc.Name())
+		}
+		return
+	}
+	delete(r.blocked, svc)
+	delete(r.breakers, svc)
+	svc.mu.Lock()
+	svc.outer = r.sup.middleware
+	svc.mu.Unlock()
+	svc.launch(r.ctx, func() {
+		r.postc <- post{svc: svc, ready: true}
+	}, func(err error) {
+		r.postc <- post{svc: svc, err: err}
+	})
+	r.running[svc] = true
+	r.sup.emit(Event{Service: svc.Name(), Type: EventStarted, Run: svc.generation()})
+	if r.booting[svc] {
+		r.sup.progress(r.bootTotal-len(r.booting), r.bootTotal, svc.Name())
+	}
+}
+
+// booted emits EventReady, and reports the progress of startup once svc is ready for the first
+// time.
+func (r *runState) booted(svc *Service) {
+	r.sup.emit(Event{Service: svc.Name(), Type: EventReady, Run: svc.generation()})
+	if !r.booting[svc] {
+		return
+	}
+	delete(r.booting, svc)
+	r.sup.progress(r.bootTotal-len(r.booting), r.bootTotal, svc.Name())
+}
+
+// exited records the exit of a service.
+func (r *runState) exited(x post) {
+	delete(r.running, x.svc)
+	r.final[x.svc] = x.err
+	snap := x.svc.Snapshot()
+	attempt := 0
+	if x.err != nil {
+		attempt = r.attempts[x.svc] + 1
+	}
+	audit := r.audits[x.svc]
+	if audit != nil {
+		delete(r.audits, x.svc)
+		x.svc.audited(audit)
+	}
+	causes := r.causes[x.svc]
+	delete(r.causes, x.svc)
+	delete(r.debounced, x.svc)
+	if len(causes) > 0 && !slices.Contains(causes, snap.Reason) {
+		causes = append([]StopReason{snap.Reason}, causes...)
+	}
+	r.sup.emit(Event{Service: snap.Name, Type: EventStopped, Reason: snap.Reason, Err: x.err,
+		Attempt: attempt, Audit: audit, Run: snap.Run, Causes: causes})
+	if x.err != nil {
+		fail := ServiceError{Name: snap.Name, Err: x.err, Attempt: attempt}
+		r.sup.dropped.Add(int64(offer(r.sup.failures, fail)))
+	}
+	if r.stopping.IsZero() {
+		r.unblock()
+	}
+}
+
+// wait waits for a stopping svc to exit, giving up after its stop timeout.
+func (r *runState) wait(svc *Service) {
+	var timeout <-chan time.Time
+	if svc.stopTimeout > 0 {
+		t := time.NewTimer(svc.stopTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	progress := time.NewTicker(shutdownProgress)
+	defer progress.Stop()
+	for r.running[svc] {
+		select {
+		case x := <-r.postc:
+			if x.ready {
+				// Shutting down, blocked services are no longer started.
+				r.booted(x.svc)
+				continue
+			}
+			r.exited(x)
+		case <-progress.C:
+			r.progress(svc)
+		case <-timeout:
+			r.sup.loggerFor(svc).Error("service did not stop in time, abandoning it",
+				"service", svc.Name(), "timeout", svc.stopTimeout)
+			r.abandon(svc, ErrStopTimeout)
+		case <-r.sup.abortc:
+			r.sup.loggerFor(svc).Error("shutdown aborted, abandoning service", "service", svc.Name())
+			r.abandon(svc, ErrAborted)
+		}
+	}
+}
+
+// abandon gives up waiting for svc to exit, recording err, and the goroutines it leaves behind,
+// as its final error.
+func (r *runState) abandon(svc *Service, err error) {
+	delete(r.running, svc)
+	n := svc.Outstanding()
+	stack := serviceStack(svc.Name())
+	r.final[svc] = &ExitError{Name: svc.Name(), Reason: svc.Snapshot().Reason,
+		Err: &AbandonedError{Err: fmt.Errorf("%w with %d in-flight", err, n), Stack: stack}}
+	svc.mu.Lock()
+	svc.abandoned++
+	svc.mu.Unlock()
+	r.sup.mu.Lock()
+	r.sup.abandoned = append(r.sup.abandoned,
+		Abandoned{Service: svc.Name(), Time: time.Now(), Stack: stack})
+	r.sup.mu.Unlock()
+}
+
+// progress reports the services yet to exit while shutdown waits on svc.
+func (r *runState) progress(svc *Service) {
+	var stopped, pending []string
+	for _, other := range r.sup.list() {
+		if r.running[other] {
+			pending = append(pending, other.Name())
+		} else {
+			stopped = append(stopped, other.Name())
+		}
+	}
+	elapsed := time.Since(r.stopping)
+	r.sup.logger.Info("shutdown in progress", "waiting", svc.Name(),
+		"elapsed", elapsed.Round(time.Millisecond), "stopped", stopped, "pending", pending)
+	r.sup.emit(Event{Service: svc.Name(), Type: EventShutdown, Elapsed: elapsed, Pending: pending})
+}
+
+// allow records a failure of svc with err, reporting whether its restart policy allows a restart.
+func (r *runState) allow(svc *Service, err error) bool {
+	p := r.sup.policyFor(svc)
+	if p == nil {
+		return true
+	}
+	now := time.Now()
+	r.failures[svc] = append(within(r.failures[svc], now, p.Window), now)
+	allowed := len(r.failures[svc]) <= p.MaxRestarts
+	var pe *PanicError
+	if errors.As(err, &pe) {
+		if p.NoRestartOnPanic {
+			return false
+		}
+		if p.MaxPanics > 0 {
+			r.panics[svc] = append(within(r.panics[svc], now, p.Window), now)
+			allowed = allowed && len(r.panics[svc]) <= p.MaxPanics
+		}
+	}
+	return allowed
+}
+
+// within returns the times in ts less than window before now, all of them if window is zero.
+func within(ts []time.Time, now time.Time, window time.Duration) []time.Time {
+	recent := ts[:0]
+	for _, t := range ts {
+		if window == 0 || now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	return recent
+}
+
+// restartAfter starts a failed svc again, after the delay given by its Backoff.
+func (r *runState) restartAfter(svc *Service) {
+	r.restarts[svc] = lastN(append(r.restarts[svc], time.Now()), crashLoopHistory)
+	r.attempts[svc]++
+	svc.mu.Lock()
+	uptime := svc.lastUptime
+	svc.mu.Unlock()
+	delay := r.sup.backoffFor(svc).Next(r.attempts[svc], uptime)
+	r.saveState()
+	if delay <= 0 {
+		r.start(svc)
+		return
+	}
+	r.delayed[svc] = true
+	time.AfterFunc(delay, func() {
+		select {
+		case r.delayc <- svc:
+		case <-r.done:
+		}
+	})
+}
+
+// held reports whether svc must not be running at now, because it is in a maintenance window,
+// does not hold its gate, is disabled, or is lazy and not yet wanted.
+func (r *runState) held(svc *Service, now time.Time) bool {
+	_, gated := r.sup.gates[svc]
+	return inWindow(r.sup.windows[svc], now) || (gated && !r.gateOpen[svc]) || !svc.isEnabled() ||
+		svc.dormant()
+}
+
+// hold pauses svc, stopping it with reason if it is running.
+func (r *runState) hold(svc *Service, reason StopReason) {
+	if r.paused[svc] {
+		return
+	}
+	r.paused[svc] = true
+	if r.running[svc] {
+		svc.StopWithReason(reason)
+	} else if reason == ReasonDisabled {
+		svc.disable()
+	}
+}
+
+// resume starts a paused svc once it has exited and is no longer held.
+func (r *runState) resume(svc *Service) {
+	if r.paused[svc] && !r.running[svc] && !r.held(svc, time.Now()) {
+		delete(r.paused, svc)
+		r.start(svc)
+	}
+}
+
+// list returns a copy of the registered services.
+func (s *Supervisor) list() []*Service {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Service(nil), s.services...)
+}
+
+// service returns the registered service with name, or nil.
+func (s *Supervisor) service(name string) *Service {
+	for _, svc := range s.list() {
+		if svc.Name() == name {
+			return svc
+		}
+	}
+	return nil
+}
+
+// startOrder returns svcs in the order they should be started, after those they require or want.
+func (s *Supervisor) startOrder(svcs []*Service) []*Service {
+	depths := make(map[*Service]int, len(svcs))
+	for _, svc := range svcs {
+		depths[svc] = s.depth(svc, make(map[*Service]bool))
+	}
+	order := slices.Clone(svcs)
+	slices.SortStableFunc(order, func(a, b *Service) int {
+		return cmp.Compare(depths[a], depths[b])
+	})
+	return order
+}
+
+// shutdownOrder returns svcs in the order they should be stopped, see WithShutdownRank.  Within
+// a rank, services are stopped before those they require.
+func (s *Supervisor) shutdownOrder(svcs []*Service) []*Service {
+	order := slices.Clone(svcs)
+	slices.Reverse(order)
+	depths := make(map[*Service]int, len(order))
+	for _, svc := range order {
+		depths[svc] = s.depth(svc, make(map[*Service]bool))
+	}
+	slices.SortStableFunc(order, func(a, b *Service) int {
+		return cmp.Or(cmp.Compare(a.stopRank, b.stopRank), cmp.Compare(depths[b], depths[a]))
+	})
+	return order
+}
+
+// supervisorKey is the context key for the Supervisor running a service.
+type supervisorKey struct{}
+
+// LoggerFromContext returns the logger for the service a Runner was started by, from its
+// context, falling back to slog.Default.  Messages are tagged with the generation of the run.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	logger := contextLogger(ctx)
+	if gen := GenerationFromContext(ctx); gen > 0 {
+		logger = logger.With("generation", gen)
+	}
+	return logger
+}
+
+// contextLogger returns the logger of the service or supervisor in ctx.
+func contextLogger(ctx context.Context) *slog.Logger {
+	svc := FromContext(ctx)
+	if sup, ok := ctx.Value(supervisorKey{}).(*Supervisor); ok {
+		if svc != nil {
+			return sup.loggerFor(svc)
+		}
+		return sup.logger
+	}
+	if svc != nil && svc.logger != nil {
+		return svc.logger
+	}
+	return slog.Default()
+}
+
+// loggerFor returns the logger for messages about svc, filtered by its runtime log level.
+func (s *Supervisor) loggerFor(svc *Service) *slog.Logger {
+	s.levelMu.Lock()
+	l := s.loggers[svc]
+	s.levelMu.Unlock()
+	if l != nil {
+		return l
+	}
+	base := svc.logger
+	if base == nil {
+		base = s.baseLogger
+	}
+	l = s.leveled(svc.Name(), base)
+	s.levelMu.Lock()
+	if s.loggers == nil {
+		s.loggers = make(map[*Service]*slog.Logger)
+	}
+	s.loggers[svc] = l
+	s.levelMu.Unlock()
+	return l
+}
+
+// emit sends an event without blocking.
+func (s *Supervisor) emit(ev Event) {
+	ev.Time = time.Now()
+	s.recentMu.Lock()
+	s.recent = lastN(append(s.recent, ev), eventHistory)
+	s.recentMu.Unlock()
+	for _, hook := range s.hooks {
+		hook(ev)
+	}
+	s.dropped.Add(int64(offer(s.events, ev)))
+}
+
+// Draining reports whether Run has begun shutting down.
+func (s *Supervisor) Draining() bool {
+	return s.draining.Load()
+}
+
+// Dropped returns the number of events and failures dropped, oldest first, because Events or
+// Failures were not read quickly enough.
+func (s *Supervisor) Dropped() int {
+	return int(s.dropped.Load())
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// swap is a blue-green replacement of a running service.
+type swap struct {
+	blue      *Service // Current instance.
+	green     *Service // Replacement instance.
+	probation time.Duration
+	ready     bool // Green is ready and has replaced blue, probation has started.
+	errc      chan error
+}
+
+// swapEvent reports a swap's green instance becoming ready, or its probation ending.
+type swapEvent struct {
+	swap          *swap
+	probationOver bool
+}
+
+// Swap replaces the implementation of the named running service with r, blue-green style: a new
+// instance performing its work with r is started alongside the current one, and once it is ready
+// the current instance is stopped with ReasonSwap.  If the new instance fails within probation,
+// the previous instance is started again.  Swap returns once probation has passed, or the swap
+// has failed.  Gated services may not be swapped.
+func (s *Supervisor) Swap(ctx context.Context, name string, r Runner,
+	probation time.Duration) error {
+	blue := s.service(name)
+	if blue == nil {
+		return fmt.Errorf("unknown service %q", name)
+	}
+	if _, gated := s.gates[blue]; gated {
+		return fmt.Errorf("service %q is gated, and cannot be swapped", name)
+	}
+	sw := &swap{blue: blue, green: blue.withRunner(r), probation: probation, errc: make(chan error, 1)}
+	select {
+	case s.swapc <- sw:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-sw.errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startSwap starts the green instance of sw, notifying swapEvc once it is ready.
+func (r *runState) startSwap(sw *swap) {
+	blue := sw.blue
+	if !r.running[blue] || r.restarting[blue] || r.paused[blue] || r.retired[blue] {
+		sw.errc <- fmt.Errorf("service %q is not running, cannot be swapped", blue.Name())
+		return
+	}
+	r.swaps[sw.green] = sw
+	r.start(sw.green)
+	ready := sw.green.Ready()
+	go func() {
+		select {
+		case <-ready:
+			r.sendSwapEvent(swapEvent{swap: sw})
+		case <-r.done:
+		}
+	}()
+}
+
+// swapped handles the green instance of a swap becoming ready, or its probation ending.
+func (r *runState) swapped(ev swapEvent) {
+	sw := ev.swap
+	if r.swaps[sw.green] != sw {
+		// Already failed.
+		return
+	}
+	if ev.probationOver {
+		delete(r.swaps, sw.green)
+		sw.errc <- nil
+		return
+	}
+	// Green is ready, make it the registered service, and retire blue.
+	r.sup.replace(sw.blue, sw.green)
+	if r.running[sw.blue] {
+		r.retired[sw.blue] = true
+		sw.blue.StopWithReason(ReasonSwap)
+	}
+	delete(r.delayed, sw.blue)
+	sw.ready = true
+	time.AfterFunc(sw.probation, func() {
+		r.sendSwapEvent(swapEvent{swap: sw, probationOver: true})
+	})
+}
+
+// swapFailed handles the green instance of a swap exiting, rolling back if it had replaced blue.
+func (r *runState) swapFailed(sw *swap, err error) {
+	delete(r.swaps, sw.green)
+	if err == nil {
+		err = errors.New("exited")
+	}
+	name := sw.blue.Name()
+	if !sw.ready {
+		sw.errc <- fmt.Errorf("swap of service %q failed to start: %w", name, err)
+		return
+	}
+	r.sup.replace(sw.green, sw.blue)
+	if r.retired[sw.blue] {
+		// Still stopping, start it again once it exits.
+		delete(r.retired, sw.blue)
+		r.restarting[sw.blue] = true
+	} else {
+		r.start(sw.blue)
+	}
+	r.sup.loggerFor(sw.blue).Error("swapped service failed in probation, rolling back",
+		"service", name, "err", err)
+	sw.errc <- fmt.Errorf("swap of service %q rolled back: %w", name, err)
+}
+
+// sendSwapEvent sends ev to the Run loop, unless Run has returned.
+func (r *runState) sendSwapEvent(ev swapEvent) {
+	select {
+	case r.swapEvc <- ev:
+	case <-r.done:
+	}
+}
+
+// replace registers svc in place of old.
+func (s *Supervisor) replace(old, svc *Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc.sup = s
+	for i := range s.services {
+		if s.services[i] == old {
+			s.services[i] = svc
+		}
+	}
+	if w, ok := s.windows[old]; ok {
+		s.windows[svc] = w
+		delete(s.windows, old)
+	}
+}
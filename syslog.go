@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogQueue    = 100 // Events held for delivery before dropping.
+	syslogFacility = 3   // daemon.
+	// syslogSDID identifies the structured data of our messages, under the enterprise number
+	// reserved for documentation by RFC 5612.
+	syslogSDID = "lifecycle@32473"
+)
+
+// syslogSockets are the local syslog sockets tried in turn, when no address is given.
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Syslog sends lifecycle events to syslog as RFC 5424 messages, for an audit trail of starts,
+// stops and failures in a central syslog, wherever application logs go.  Register Notify
+// WithEventHook.  Messages are queued and sent in the background.
+type Syslog struct {
+	network string
+	addr    string
+	host    string
+	app     string
+	filter  func(Event) bool
+	logger  *slog.Logger
+	queue   chan Event
+	done    chan struct{}
+	conn    net.Conn // Owned by send.
+
+	mu      sync.Mutex // Protects dropped.
+	dropped int
+}
+
+// NewSyslog creates a Syslog sending events matching filter to addr over network, "udp" or
+// "tcp", or to the local syslog if network is empty, and starts sending them.  If filter is nil,
+// EventStarted, EventStopped, EventBreakerOpen and EventShutdownComplete are sent.
+func NewSyslog(network, addr string, filter func(Event) bool) *Syslog {
+	if filter == nil {
+		filter = func(ev Event) bool {
+			switch ev.Type {
+			case EventStarted, EventStopped, EventBreakerOpen, EventShutdownComplete:
+				return true
+			}
+			return false
+		}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "-"
+	}
+	s := &Syslog{
+		network: network,
+		addr:    addr,
+		host:    host,
+		app:     filepath.Base(os.Args[0]),
+		filter:  filter,
+		logger:  slog.Default(),
+		queue:   make(chan Event, syslogQueue),
+		done:    make(chan struct{}),
+	}
+	go s.send()
+	return s
+}
+
+// Notify queues ev to be sent if it matches our filter, dropping the oldest queued if full.
+func (s *Syslog) Notify(ev Event) {
+	if !s.filter(ev) {
+		return
+	}
+	if n := offer(s.queue, ev); n > 0 {
+		s.mu.Lock()
+		s.dropped += n
+		s.mu.Unlock()
+	}
+}
+
+// Dropped returns the number of events dropped, oldest first, because the queue was full.
+func (s *Syslog) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close stops accepting events, waiting for those queued to be sent until ctx is done.  Notify
+// must not be called after Close.
+func (s *Syslog) Close(ctx context.Context) error {
+	close(s.queue)
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// send sends queued events until the queue is closed, reconnecting after errors.
+func (s *Syslog) send() {
+	defer close(s.done)
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+	for ev := range s.queue {
+		msg := s.format(ev)
+		var err error
+		for range 2 {
+			if err = s.write(msg); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			s.logger.Error("syslog delivery failed", "addr", s.addr, "event", ev.Type, "err", err)
+		}
+	}
+}
+
+// write sends msg on our connection, dialing it first if needed, and closing it on failure.
+func (s *Syslog) write(msg string) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	if s.network == "tcp" {
+		// Octet counting framing, RFC 6587.
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(time.Second * 5))
+	if _, err := s.conn.Write([]byte(msg)); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// dial connects to the syslog server, or the first local syslog socket found.
+func (s *Syslog) dial() (net.Conn, error) {
+	if s.network != "" {
+		return net.DialTimeout(s.network, s.addr, time.Second*5)
+	}
+	var err error
+	for _, path := range syslogSockets {
+		var conn net.Conn
+		if conn, err = net.Dial("unixgram", path); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no local syslog: %w", err)
+}
+
+// format returns ev as an RFC 5424 message, with the service, reason and run of ev as
+// structured data.
+func (s *Syslog) format(ev Event) string {
+	var sd strings.Builder
+	sd.WriteString("[" + syslogSDID)
+	for _, p := range [][2]string{
+		{"service", ev.Service},
+		{"reason", string(ev.Reason)},
+		{"run", fmt.Sprint(ev.Run)},
+	} {
+		if p[1] != "" && p[1] != "0" {
+			fmt.Fprintf(&sd, " %s=\"%s\"", p[0], syslogEscape(p[1]))
+		}
+	}
+	sd.WriteString("]")
+	pri := syslogFacility*8 + syslogSeverity(ev)
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s", pri, ev.Time.Format(time.RFC3339Nano),
+		s.host, s.app, os.Getpid(), ev.Type, sd.String(), ev)
+}
+
+// syslogSeverity returns the severity of ev: err for failures, notice for the supervisor and
+// info for other events.
+func syslogSeverity(ev Event) int {
+	switch {
+	case ev.Err != nil || ev.Type == EventBreakerOpen:
+		return 3
+	case ev.Service == "":
+		return 5
+	}
+	return 6
+}
+
+// syslogEscape escapes the characters RFC 5424 requires escaped in structured data values.
+func syslogEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
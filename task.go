@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// PanicError is the error a recovered panic is converted to.
+type PanicError struct {
+	Value any    // Value passed to panic.
+	Stack []byte // Stack of the panicking goroutine.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Go runs fn in a new goroutine, as in-flight work of the service.  The context passed to fn is
+// canceled when the service stops.  If fn returns an error or panics, the service is stopped and
+// fails with that error.  If the service was created WithMaxTasks, Go blocks until fewer tasks
+// are running, returning an error if the service stops first.
+func (s *Service) Go(fn func(ctx context.Context) error) error {
+	s.mu.Lock()
+	ctx := s.ctx
+	s.mu.Unlock()
+	if ctx == nil || ctx.Err() != nil {
+		return errors.New("service " + s.name + " is not running")
+	}
+	if s.tasks != nil {
+		select {
+		case s.tasks <- struct{}{}:
+		case <-ctx.Done():
+			return fmt.Errorf("service %s stopped waiting for a task slot: %w", s.name, ctx.Err())
+		}
+	}
+	s.Track()
+	go func() {
+		defer s.Done()
+		if s.tasks != nil {
+			defer func() { <-s.tasks }()
+		}
+		if err := s.call(ctx, fn); err != nil && ctx.Err() == nil {
+			s.fail(err)
+		}
+	}()
+	return nil
+}
+
+// panicHandler is set by SetPanicHandler.
+var panicHandler atomic.Pointer[func(svc string, recovered any, stack []byte)]
+
+// SetPanicHandler sets a handler called with panics recovered from services and their tasks,
+// before the panic is converted to a PanicError.  It may, for example, crash the process
+// intentionally or write a crash report.  A nil handler removes it.
+func SetPanicHandler(h func(svc string, recovered any, stack []byte)) {
+	if h == nil {
+		panicHandler.Store(nil)
+		return
+	}
+	panicHandler.Store(&h)
+}
+
+// call calls fn, converting a panic into a PanicError.
+func (s *Service) call(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			if h := panicHandler.Load(); h != nil {
+				(*h)(s.name, v, stack)
+			}
+			err = &PanicError{Value: v, Stack: stack}
+		}
+	}()
+	return fn(ctx)
+}
+
+// fail stops the service with ReasonFailed, recording err as its final error unless another task
+// already failed.
+func (s *Service) fail(err error) {
+	s.mu.Lock()
+	if s.taskErr == nil {
+		s.taskErr = fmt.Errorf("task: %w", err)
+	}
+	s.mu.Unlock()
+	s.StopWithReason(ReasonFailed)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sync/atomic"
+)
+
+// TLSFiles are the PEM encoded certificate chain and private key files of a CertReloader.
+type TLSFiles struct {
+	Cert string
+	Key  string
+}
+
+// CertReloader serves a TLS certificate loaded from files, which can be replaced without
+// restarting the servers using it, e.g. when renewed.  Run it as a service consuming a
+// Config[TLSFiles], so each Reload or Update of the config loads the files again, and serve with
+// its TLSConfig.
+type CertReloader struct {
+	files atomic.Pointer[TLSFiles]
+	cert  atomic.Pointer[tls.Certificate]
+}
+
+// NewCertReloader creates a CertReloader serving the certificate in files, failing if it cannot
+// be loaded.
+func NewCertReloader(files TLSFiles) (*CertReloader, error) {
+	c := &CertReloader{}
+	if err := c.load(files); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run implements Runner, doing nothing until ctx is done, so the CertReloader can be the service
+// receiving config updates.
+func (c *CertReloader) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+// ApplyConfig implements ConfigApplier, loading the certificate from files.  The previous
+// certificate is kept if it fails to load.
+func (c *CertReloader) ApplyConfig(ctx context.Context, files TLSFiles) error {
+	if err := c.load(files); err != nil {
+		return err
+	}
+	LoggerFromContext(ctx).Info("reloaded TLS certificate", "cert", files.Cert)
+	return nil
+}
+
+// Reload loads the certificate from our files again, e.g. after they were replaced in place.
+func (c *CertReloader) Reload() error {
+	return c.load(*c.files.Load())
+}
+
+// GetCertificate returns the current certificate, for tls.Config.GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return c.cert.Load(), nil
+}
+
+// TLSConfig returns a tls.Config serving the current certificate, for http.Server.TLSConfig.
+// Callers may set other fields, e.g. ClientCAs and ClientAuth to authenticate clients by
+// certificate, see AdminClient.
+func (c *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: c.GetCertificate}
+}
+
+// load loads the certificate from files, making it current.
+func (c *CertReloader) load(files TLSFiles) error {
+	cert, err := tls.LoadX509KeyPair(files.Cert, files.Key)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	c.files.Store(&files)
+	c.cert.Store(&cert)
+	return nil
+}
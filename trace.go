@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Span is a unit of traced work, see Tracer.
+type Span interface {
+	End(err error)
+}
+
+// Tracer starts spans for the Trace middleware, adapting it to a tracing backend such as
+// OpenTelemetry.  Start returns a context carrying the span, so that work done with it is linked
+// to the span.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, Span)
+}
+
+// Trace returns Middleware starting a span with t for each run of a service, ending it as the
+// run ends.  Run is passed a context carrying the span, linking restarts to the traces of the
+// work each run performed.
+func Trace(t Tracer) Middleware {
+	return func(r Runner) Runner {
+		return RunFunc(func(ctx context.Context) error {
+			name, attempt := "", 0
+			if svc := FromContext(ctx); svc != nil {
+				svc.mu.Lock()
+				name, attempt = svc.name, svc.runs
+				svc.mu.Unlock()
+			}
+			ctx, span := t.Start(ctx, "run "+name,
+				slog.String("service", name), slog.Int("attempt", attempt))
+			err := r.Run(ctx)
+			span.End(err)
+			return err
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// upgradeStateEnv names the environment variable passing the state file to an upgraded binary.
+const upgradeStateEnv = "START_STOP_UPGRADE_STATE"
+
+// upgrade is a request to replace this process with a new binary.
+type upgrade struct {
+	path string
+	errc chan error
+}
+
+// Upgrade gracefully stops all services, and then replaces this process with the binary at path,
+// preserving its arguments and environment.  The new process resumes supervision where this one
+// left off, see resumeUpgrade.  Upgrade only returns if the upgrade fails, in which case Run also
+// returns the error.
+func (s *Supervisor) Upgrade(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	req := upgrade{path: path, errc: make(chan error, 1)}
+	select {
+	case s.upgradec <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// execUpgrade writes our state to a file with SaveState, and execs the upgrade binary.
+func (s *Supervisor) execUpgrade(req upgrade) error {
+	f, err := os.CreateTemp("", "start-stop-upgrade-*.json")
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	err = s.SaveState(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		s.logger.Info("upgrading", "path", req.path)
+		env := append(os.Environ(), upgradeStateEnv+"="+f.Name())
+		err = execBinary(req.path, os.Args, env)
+	}
+	os.Remove(f.Name())
+	return fmt.Errorf("upgrade: %w", err)
+}
+
+// resumeUpgrade loads state handed over by the process we were exec'd from, if any, with
+// LoadState.
+func (s *Supervisor) resumeUpgrade() {
+	path := os.Getenv(upgradeStateEnv)
+	if path == "" {
+		return
+	}
+	os.Unsetenv(upgradeStateEnv)
+	defer os.Remove(path)
+	f, err := os.Open(path)
+	if err == nil {
+		err = s.LoadState(f)
+		f.Close()
+	}
+	if err != nil {
+		s.logger.Error("failed to load upgrade state", "path", path, "err", err)
+		return
+	}
+	s.logger.Info("resuming after upgrade")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// verifyInterval is how often a failing post-start verification is retried.
+const verifyInterval = time.Millisecond * 250
+
+// ErrVerify is the failure of a service whose verification, see WithVerify, did not pass.
+var ErrVerify = errors.New("post-start verification failed")
+
+// runVerified runs the service with r alongside its verification, only marking it ready once the
+// verification passes, and failing the run if it does not.
+func (s *Service) runVerified(ctx context.Context, r Runner) error {
+	rctx, cancel := context.WithCancelCause(ctx)
+	verified := make(chan struct{})
+	go func() {
+		defer close(verified)
+		if err := s.verifyStart(rctx); err != nil {
+			cancel(fmt.Errorf("%w: %w", ErrVerify, err))
+			return
+		}
+		s.markReady()
+	}()
+	err := r.Run(rctx)
+	cancel(nil)
+	<-verified
+	if cause := context.Cause(rctx); errors.Is(cause, ErrVerify) && ctx.Err() == nil {
+		return fmt.Errorf("start: %w", cause)
+	}
+	return err
+}
+
+// verifyStart retries the verification of the service until it passes, or its start timeout
+// elapses, returning the last failure.
+func (s *Service) verifyStart(ctx context.Context) error {
+	timeout := s.startTimeout
+	if timeout <= 0 {
+		timeout = healthTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(verifyInterval)
+	defer ticker.Stop()
+	for {
+		err := s.verify(ctx)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
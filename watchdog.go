@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// MemoryWatchdog periodically samples memory usage, gracefully restarting leak-prone services
+// when it exceeds a threshold.
+type MemoryWatchdog struct {
+	sup      *Supervisor
+	interval time.Duration
+	cooldown time.Duration
+	watches  []*memoryWatch
+}
+
+// memoryWatch restarts names when usage exceeds limit.
+type memoryWatch struct {
+	names []string
+	limit uint64
+	usage func() uint64
+	last  time.Time // Time of the last restart, for cooldown.
+}
+
+// NewMemoryWatchdog creates a watchdog for services in sup, sampling every interval.  After
+// triggering a restart, a watch will not trigger again until cooldown has elapsed, giving the
+// runtime time to return memory.
+func NewMemoryWatchdog(sup *Supervisor, interval, cooldown time.Duration) *MemoryWatchdog {
+	return &MemoryWatchdog{sup: sup, interval: interval, cooldown: cooldown}
+}
+
+// Validate implements Validator, checking the sampling interval is positive.
+func (w *MemoryWatchdog) Validate() error {
+	if w.interval <= 0 {
+		return fmt.Errorf("non-positive memory sampling interval %v", w.interval)
+	}
+	return nil
+}
+
+// Watch restarts the named services when the process heap exceeds limit bytes.
+func (w *MemoryWatchdog) Watch(limit uint64, names ...string) {
+	w.watches = append(w.watches, &memoryWatch{names: names, limit: limit, usage: heapUsage})
+}
+
+// WatchService restarts the named service when usage, as reported by the service itself, exceeds
+// limit bytes.
+func (w *MemoryWatchdog) WatchService(name string, limit uint64, usage func() uint64) {
+	w.watches = append(w.watches, &memoryWatch{names: []string{name}, limit: limit, usage: usage})
+}
+
+// Run implements Runner, sampling memory usage until ctx is done, so the watchdog can run as a
+// service of sup.  Watches must not be added once Run has been called.
+func (w *MemoryWatchdog) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.sample()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// sample checks each watch once.
+func (w *MemoryWatchdog) sample() {
+	for _, mw := range w.watches {
+		if time.Since(mw.last) < w.cooldown {
+			continue
+		}
+		usage := mw.usage()
+		if usage <= mw.limit {
+			continue
+		}
+		mw.last = time.Now()
+		for _, name := range mw.names {
+			w.sup.emit(Event{Service: name, Type: EventMemoryExceeded, Usage: usage})
+			if err := w.sup.Restart(name, ReasonMemory); err != nil {
+				w.sup.logger.Error("memory watchdog failed to restart service", "service", name,
+					"err", err)
+			}
+		}
+	}
+}
+
+// heapUsage returns the bytes of allocated heap objects in this process.
+func heapUsage() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	webhookQueue   = 100 // Events held for delivery before dropping.
+	webhookRetries = 3   // Delivery attempts for each URL.
+	webhookBackoff = time.Second
+	webhookTimeout = 10 * time.Second // Limits each delivery attempt.
+)
+
+// Webhook POSTs events as JSON to URLs, so external orchestration or alerting can react without
+// polling.  Register Notify WithEventHook.  Deliveries are queued and retried in the background,
+// each attempt limited to webhookTimeout.
+type Webhook struct {
+	client *http.Client
+	urls   []string
+	filter func(Event) bool
+	logger *slog.Logger
+	queue  chan Event
+	done   chan struct{}
+	ctx    context.Context // Canceled to abandon deliveries, see Close.
+	cancel context.CancelFunc
+
+	mu      sync.Mutex // Protects dropped.
+	dropped int
+}
+
+// WebhookPayload is the JSON body POSTed by a Webhook.
+type WebhookPayload struct {
+	Time    time.Time  `json:"time"`
+	Service string     `json:"service,omitempty"`
+	Type    EventType  `json:"type"`
+	Reason  StopReason `json:"reason,omitempty"`
+	Err     string     `json:"error,omitempty"`
+}
+
+// NewWebhook creates a Webhook POSTing events matching filter to urls, and starts delivering
+// them.  If filter is nil, service failures, EventBreakerOpen and EventShutdownComplete are
+// delivered.
+func NewWebhook(client *http.Client, urls []string, filter func(Event) bool) *Webhook {
+	if filter == nil {
+		filter = func(ev Event) bool {
+			return ev.Type == EventBreakerOpen || ev.Type == EventShutdownComplete ||
+				(ev.Type == EventStopped && ev.Err != nil)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Webhook{
+		client: client,
+		urls:   urls,
+		filter: filter,
+		logger: slog.Default(),
+		queue:  make(chan Event, webhookQueue),
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	go w.deliver()
+	return w
+}
+
+// Notify queues ev for delivery if it matches our filter, dropping the oldest queued if full.
+func (w *Webhook) Notify(ev Event) {
+	if !w.filter(ev) {
+		return
+	}
+	if n := offer(w.queue, ev); n > 0 {
+		w.mu.Lock()
+		w.dropped += n
+		w.mu.Unlock()
+	}
+}
+
+// Dropped returns the number of events dropped, oldest first, because the queue was full.
+func (w *Webhook) Dropped() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.dropped
+}
+
+// Close stops accepting events, waiting for those queued to be delivered until ctx is done, when
+// the delivery in progress is canceled and the rest abandoned.  Notify must not be called after
+// Close.
+func (w *Webhook) Close(ctx context.Context) error {
+	close(w.queue)
+	defer w.cancel()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// deliver POSTs queued events until the queue is closed.
+func (w *Webhook) deliver() {
+	defer close(w.done)
+	for ev := range w.queue {
+		p := WebhookPayload{
+			Time:    ev.Time,
+			Service: ev.Service,
+			Type:    ev.Type,
+			Reason:  ev.Reason,
+		}
+		if ev.Err != nil {
+			p.Err = ev.Err.Error()
+		}
+		body, err := json.Marshal(p)
+		if err != nil {
+			continue
+		}
+		for _, url := range w.urls {
+			if w.ctx.Err() != nil {
+				break
+			}
+			if err := w.post(url, body); err != nil {
+				w.logger.Error("webhook delivery failed", "url", url, "event", ev.Type, "err", err)
+			}
+		}
+	}
+}
+
+// post sends body to url, retrying failures, until the deliveries are abandoned.
+func (w *Webhook) post(url string, body []byte) error {
+	var err error
+	for attempt := range webhookRetries {
+		if attempt > 0 {
+			t := time.NewTimer(webhookBackoff << (attempt - 1))
+			select {
+			case <-t.C:
+			case <-w.ctx.Done():
+				t.Stop()
+				return w.ctx.Err()
+			}
+		}
+		if err = w.attempt(url, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// attempt sends body to url once, limited to webhookTimeout.
+func (w *Webhook) attempt(url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(w.ctx, webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}